@@ -26,7 +26,6 @@ package astparser
 import "github.com/byte-mug/dream/values"
 import "github.com/byte-mug/semiparse/scanlist"
 import "text/scanner"
-import "regexp"
 import "fmt"
 
 const (
@@ -40,8 +39,11 @@ const (
 	KW_le
 	KW_gt
 	KW_ge
+	KW_cmp
 	KW_m
 	KW_s
+	KW_qr
+	KW_tr
 	KW_my
 	KW_if
 	KW_unless
@@ -52,6 +54,7 @@ const (
 	KW_do
 	KW_eval
 	KW_package
+	KW_our
 	KW_max_
 )
 
@@ -65,8 +68,11 @@ var Keywords = scanlist.TokenDict{
 	"le"     : KW_le,
 	"gt"     : KW_gt,
 	"ge"     : KW_ge,
+	"cmp"    : KW_cmp,
 	"m"      : KW_m,
 	"s"      : KW_s,
+	"qr"     : KW_qr,
+	"tr"     : KW_tr,
 	"my"     : KW_my,
 	"if"     : KW_if,
 	"unless" : KW_unless,
@@ -77,6 +83,7 @@ var Keywords = scanlist.TokenDict{
 	"do"     : KW_do,
 	"eval"   : KW_eval,
 	"package": KW_package,
+	"our"    : KW_our,
 }
 
 type hasPosition interface{
@@ -102,6 +109,7 @@ type callExpr interface{
 type ELiteral struct {
 	Scalar values.Scalar
 	Pos scanner.Position
+	Bytes bool // a string with non-ASCII source text, which is a byte string without "use utf8;"
 }
 func (e *ELiteral) String() string  {
 	if _,ok := e.Scalar.(values.ScString); ok { return fmt.Sprintf("#%q",e.Scalar) }
@@ -132,6 +140,13 @@ type EArrayScalar struct{ // $..[...]
 func (e *EArrayScalar) String() string  { return fmt.Sprint("$",e.Name,"[",e.Index,"]") }
 func (e *EArrayScalar) position() scanner.Position { return e.Pos }
 
+type ELastIndex struct{ // $#..
+	Name interface{} // string | expression
+	Pos scanner.Position
+}
+func (e *ELastIndex) String() string  { return fmt.Sprint("$#",e.Name) }
+func (e *ELastIndex) position() scanner.Position { return e.Pos }
+
 type EUnop struct{
 	Op string // operation
 	A interface{} // operand
@@ -140,6 +155,18 @@ type EUnop struct{
 func (e *EUnop) String() string  { return fmt.Sprint("(",e.Op," ",e.A,")") }
 func (e *EUnop) position() scanner.Position { return e.Pos }
 
+type EIncDec struct{
+	Op string // "++" or "--"
+	A interface{} // operand, an lvalue
+	Post bool // $i++ rather than ++$i
+	Pos scanner.Position
+}
+func (e *EIncDec) String() string  {
+	if e.Post { return fmt.Sprint("(",e.A," ",e.Op,")") }
+	return fmt.Sprint("(",e.Op," ",e.A,")")
+}
+func (e *EIncDec) position() scanner.Position { return e.Pos }
+
 type EBinop struct{
 	Op string // operation
 	A,B interface{} // operands
@@ -150,30 +177,59 @@ func (e *EBinop) position() scanner.Position { return e.Pos }
 
 type EMatchGlobal struct{
 	A interface{} // operand
-	Rx *regexp.Regexp // regexp
+	Rx values.Regex // regexp
 	Pos scanner.Position
 }
 func (e *EMatchGlobal) String() string  { return fmt.Sprint("(",e.A," =~ m/",e.Rx,"/g)") }
 func (e *EMatchGlobal) position() scanner.Position { return e.Pos }
 func (e *EMatchGlobal) array() {}
+func (e *EMatchGlobal) IsHybrid() {}
+
+type EPos struct{ // pos($x)
+	Var interface{} // scalar variable
+	Pos scanner.Position
+}
+func (e *EPos) String() string  { return fmt.Sprint("pos(",e.Var,")") }
+func (e *EPos) position() scanner.Position { return e.Pos }
 
 type EMatch struct{
 	A interface{} // operand
-	Rx *regexp.Regexp // regexp
+	Rx values.Regex // regexp
 	Pos scanner.Position
+	Pat interface{} // pattern expression ($s =~ $re), if Rx is nil
+}
+func (e *EMatch) String() string  {
+	if e.Rx==nil { return fmt.Sprint("(",e.A," =~ ",e.Pat,")") }
+	return fmt.Sprint("(",e.A," =~ m/",e.Rx,"/)")
 }
-func (e *EMatch) String() string  { return fmt.Sprint("(",e.A," =~ m/",e.Rx,"/)") }
 func (e *EMatch) position() scanner.Position { return e.Pos }
 
+type ERegex struct{ // qr/.../
+	Rx values.Regex // regexp
+	Pos scanner.Position
+}
+func (e *ERegex) String() string  { return fmt.Sprint("qr/",e.Rx,"/") }
+func (e *ERegex) position() scanner.Position { return e.Pos }
+
 type EReplace struct{
 	A interface{} // operand
-	Rx *regexp.Regexp // regexp
+	Rx values.Regex // regexp
 	B interface{} // operand (replacement)
 	Pos scanner.Position
+	Flags string // modifiers (g, r, ...)
 }
 func (e *EReplace) String() string  { return fmt.Sprint("(",e.A," =~ s/",e.Rx,"/ ",e.B,")") }
 func (e *EReplace) position() scanner.Position { return e.Pos }
 
+type ETrans struct{ // tr/SEARCH/REPLACE/
+	A interface{} // operand
+	Tr *values.Trans
+	Pos scanner.Position
+	Flags string // modifiers (c, d, s, r)
+}
+func (e *ETrans) String() string  { return fmt.Sprint("(",e.A," =~ tr/.../",e.Flags,")") }
+func (e *ETrans) position() scanner.Position { return e.Pos }
+
 
 type EScAssign struct{
 	A,B interface{} // A := B
@@ -266,6 +322,26 @@ func (e *EGoFunction) String() string  { return fmt.Sprint("go ",e.Call) }
 func (e *EGoFunction) position() scanner.Position { return e.Pos }
 func (e *EGoFunction) IsHybrid() {}
 
+type EMy struct{ // my $x
+	Name string // with sigil
+	Pos scanner.Position
+}
+func (e *EMy) String() string  { return fmt.Sprint("my ",e.Name) }
+func (e *EMy) position() scanner.Position { return e.Pos }
+
+type EWantArray struct{ // wantarray
+	Pos scanner.Position
+}
+func (e *EWantArray) String() string  { return "wantarray" }
+func (e *EWantArray) position() scanner.Position { return e.Pos }
+
+type EExists struct{ // exists $h{...}
+	Expr interface{} // EHashScalar | EArrayScalar
+	Pos scanner.Position
+}
+func (e *EExists) String() string  { return fmt.Sprint("exists(",e.Expr,")") }
+func (e *EExists) position() scanner.Position { return e.Pos }
+
 
 func ToScalarExpr(ast interface{}) interface{} {
 	if _,ok := ast.(hybridExpr); ok { return ast }
@@ -334,6 +410,14 @@ func (e *AConcat) position() scanner.Position { return e.Pos }
 func (e *AConcat) array() {}
 
 
+type AMy struct{ // my @x, my ($a,%b)
+	Vars []interface{} // variables (as string)
+	Pos scanner.Position
+}
+func (e *AMy) String() string  { return fmt.Sprint("my ",e.Vars) }
+func (e *AMy) position() scanner.Position { return e.Pos }
+func (e *AMy) array() {}
+
 type AExIfElse struct{
 	Cond, Then, Else interface{} // $a ? @b : @c
 	Pos scanner.Position
@@ -355,68 +439,127 @@ type SMyVars struct{ // my $a,@b,%c ...
 	Vars []interface{} // variables (as string)
 	Pos scanner.Position
 }
+func (s *SMyVars) position() scanner.Position { return s.Pos }
+
+type SOurVars struct{ // our $a,@b,%c ...
+	Vars []interface{} // variables (as string)
+	Pos scanner.Position
+}
+func (s *SOurVars) position() scanner.Position { return s.Pos }
 
 type SExpr struct{ // <expression>;
 	Expr interface{}
 	Pos scanner.Position
 }
+func (s *SExpr) position() scanner.Position { return s.Pos }
 
 type SArray struct{ // <expression>;
 	Expr interface{}
 	Pos scanner.Position
 }
+func (s *SArray) position() scanner.Position { return s.Pos }
 
 
 type SPrint struct { // print <expr>;
 	Expr interface{}
 	Pos scanner.Position
 }
+func (s *SPrint) position() scanner.Position { return s.Pos }
 
 type SBlock struct{ // { ... }
 	Stmts []interface{}
 	Pos scanner.Position
 }
+func (s *SBlock) position() scanner.Position { return s.Pos }
 
 type SCond struct{
 	Type string
 	Cond, Body interface{}
 	Pos scanner.Position
 }
+func (s *SCond) position() scanner.Position { return s.Pos }
 
 type SIfElse struct{
 	Type string
 	Cond, Body, Else interface{}
 	Pos scanner.Position
 }
+func (s *SIfElse) position() scanner.Position { return s.Pos }
 type SNoop struct{
 	Pos scanner.Position
 }
+func (s *SNoop) position() scanner.Position { return s.Pos }
 type SFor struct{ // for $a (@b) {...}
 	Var string
 	Src, Body interface{}
 	Pos scanner.Position
+	My bool // for my $a (@b) {...}
 }
+func (s *SFor) position() scanner.Position { return s.Pos }
 type SEval struct{
 	Body interface{}
 	Pos scanner.Position
 }
+func (s *SEval) position() scanner.Position { return s.Pos }
+
+type SReturn struct{ // return <expr>;
+	Expr interface{} // nil for "return;"
+	Pos scanner.Position
+}
+func (s *SReturn) position() scanner.Position { return s.Pos }
 
 type SLoopJump struct{
-	Op string // next | last
+	Op string // next | last | redo
+	Pos scanner.Position
+	Label string // next LABEL;
+}
+func (s *SLoopJump) position() scanner.Position { return s.Pos }
+type SLabeled struct{ // LABEL: <stmt>
+	Label string
+	Body interface{}
 	Pos scanner.Position
 }
+func (s *SLabeled) position() scanner.Position { return s.Pos }
 
 type SRequireStatic struct{
 	Mod string
 	Pos scanner.Position
 }
+func (s *SRequireStatic) position() scanner.Position { return s.Pos }
 type SRequireDynamic struct{
 	Mod interface{} // expr
 	Pos scanner.Position
 }
+func (s *SRequireDynamic) position() scanner.Position { return s.Pos }
+
+type SPackage struct{ // package Foo; ... | package Foo { ... }
+	Name string
+	Body interface{}
+	Pos scanner.Position
+}
+func (s *SPackage) position() scanner.Position { return s.Pos }
+
+// Marks the place of a sub definition within the module body. It generates no code.
+type SSubDecl struct{
+	Sub *MDSub
+}
 
 type MDPackage struct{
 	Name string
+	Body []interface{} // nil for "package Foo;"
+	Pos scanner.Position
+}
+
+type MDUse struct{ // use Foo::Bar qw(a $b);
+	Mod string
+	Imports []string // nil: import @EXPORT
+	Pos scanner.Position
+	Package string
+}
+
+type MDParam struct{ // $x | $x = <expr> | @rest | %opts
+	Name string // variable (with sigil)
+	Default interface{} // expression or nil
 	Pos scanner.Position
 }
 
@@ -424,11 +567,18 @@ type MDSub struct{
 	Name string
 	Body interface{}
 	Pos scanner.Position
+	Package string // "" for the module itself
+	Sig []*MDParam // nil, if the sub has no signature
 }
 
 
 type Module struct{
 	Main *MDSub
 	Subs []*MDSub
+	Packages []string // packages declared within the file
+	Uses []*MDUse
+	Strict bool // use strict;
+	Regex *MDUse // use re qw(engine);
+	UTF8 bool // use utf8;
 }
 
@@ -30,7 +30,34 @@ import "github.com/byte-mug/dream/parsex"
 var mdecl_sub = parser.ArraySeq{
 	require(KW_sub),
 	parsex.Snip{parser.Pfunc(d_ident)},
-	parsex.Snip{parser.Pfunc(d_stmt_block)},
+}
+
+var mdecl_param = parser.ArraySeq{declSigil, parsex.Snip{parser.Pfunc(d_ident)}}
+var mdecl_param_default = parser.LSeq{require('='), parsex.Snip{parser.Delegate("Expr3")}}
+
+func d_mdecl_param(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
+	res := mdecl_param.Parse(p,tokens,nil)
+	if !res.Ok() { return res }
+	
+	arr := res.Data.([]interface{})
+	
+	param := &MDParam{arr[0].(string)+arr[1].(string),nil,tokens.Pos}
+	def := mdecl_param_default.Parse(p,res.Next,nil)
+	switch {
+	case def.Ok():
+		param.Default = def.Data
+		res.Next = def.Next
+	case def.Result==parser.RESULT_FAILED_CUT:
+		return def
+	}
+	res.Data = param
+	
+	return res
+}
+
+var mdecl_sig = parser.OR{
+	parser.ArraySeq{require('('),require(')')},
+	parser.ArraySeq{require('('),parsex.Snip{parsex.ArrayDelimited{parser.Pfunc(d_mdecl_param),require(',')}},parsex.Snip{require(')')}},
 }
 
 func d_mdecl_sub(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
@@ -39,15 +66,39 @@ func d_mdecl_sub(p *parser.Parser,tokens *scanlist.Element, left interface{}) pa
 	
 	arr := res.Data.([]interface{})
 	
-	res.Data = &MDSub{arr[1].(string),arr[2],tokens.Pos}
+	sub := &MDSub{arr[1].(string),nil,tokens.Pos,"",nil}
 	
-	return res
+	// sub name($a, $b = 1, @rest) {...}
+	sig := mdecl_sig.Parse(p,res.Next,nil)
+	switch {
+	case sig.Ok():
+		sub.Sig = []*MDParam{}
+		if sarr := sig.Data.([]interface{}); len(sarr)==3 {
+			for _,param := range sarr[1].([]interface{}) { sub.Sig = append(sub.Sig,param.(*MDParam)) }
+		}
+		res.Next = sig.Next
+	case sig.Result==parser.RESULT_FAILED_CUT:
+		return sig
+	}
+	
+	body := parsex.DoCut(d_stmt_block(p,res.Next,nil))
+	if !body.Ok() { return body }
+	sub.Body = body.Data
+	body.Data = sub
+	
+	return body
+}
+
+var mdecl_package_block = parser.ArraySeq{
+	stmt_block_o,
+	parsex.ArrayBreakIf{parsex.Snip{parser.Delegate("Melem")},stmt_block_c},
+	stmt_block_c,
 }
 
 var mdecl_package = parser.ArraySeq{
 	require(KW_package),
 	parsex.Snip{parser.Pfunc(d_module_name)},
-	parsex.Snip{require(';')},
+	parsex.Snip{parser.OR{require(';'),mdecl_package_block}},
 }
 func d_mdecl_package(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
 	res := mdecl_package.Parse(p,tokens,nil)
@@ -55,7 +106,34 @@ func d_mdecl_package(p *parser.Parser,tokens *scanlist.Element, left interface{}
 	
 	arr := res.Data.([]interface{})
 	
-	res.Data = &MDPackage{arr[1].(string),tokens.Pos}
+	pkg := &MDPackage{arr[1].(string),nil,tokens.Pos}
+	if blk,ok := arr[2].([]interface{}); ok { pkg.Body = blk[1].([]interface{}) }
+	res.Data = pkg
+	
+	return res
+}
+
+var mdecl_use = parser.ArraySeq{
+	parser.RequireText{"use"},
+	parsex.Snip{parser.Pfunc(d_module_name)},
+	parser.OR{
+		require(';'),
+		parser.ArraySeq{require('('),require(')'),parsex.Snip{require(';')}},
+		parser.ArraySeq{parser.Pfunc(d_qw),parsex.Snip{require(';')}},
+	},
+}
+func d_mdecl_use(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
+	res := mdecl_use.Parse(p,tokens,nil)
+	if !res.Ok() { return res }
+	
+	arr := res.Data.([]interface{})
+	
+	use := &MDUse{arr[1].(string),nil,tokens.Pos,""}
+	if lst,ok := arr[2].([]interface{}); ok {
+		use.Imports = []string{}
+		if words,ok := lst[0].([]string); ok { use.Imports = words }
+	}
+	res.Data = use
 	
 	return res
 }
@@ -68,12 +146,56 @@ func d_melem_mdecl(p *parser.Parser,tokens *scanlist.Element, left interface{})
 	return res
 }
 
+/*
+Sorts the module elements into subs and statements. A "package Foo;" declaration
+applies to the rest of the list, a "package Foo {...}" declaration to its body only.
+*/
+func (m *Module) collect(pkg string, elems []interface{}) (stmts []interface{}) {
+	for i,elem := range elems {
+		switch e := elem.(type) {
+		case *mDecl:
+			switch t := e.I.(type) {
+			case *MDSub:
+				t.Package = pkg
+				m.Subs = append(m.Subs,t)
+				stmts = append(stmts,&SSubDecl{t})
+			case *MDUse:
+				if t.Mod=="strict" { // pragma
+					m.Strict = true
+					continue
+				}
+				if t.Mod=="utf8" { // pragma
+					m.UTF8 = true
+					continue
+				}
+				if t.Mod=="re" { // pragma
+					m.Regex = t
+					continue
+				}
+				t.Package = pkg
+				m.Uses = append(m.Uses,t)
+			case *MDPackage:
+				m.Packages = append(m.Packages,t.Name)
+				if t.Body!=nil {
+					stmts = append(stmts,&SPackage{t.Name,&SBlock{m.collect(t.Name,t.Body),t.Pos},t.Pos})
+					continue
+				}
+				rest := m.collect(t.Name,elems[i+1:])
+				stmts = append(stmts,&SPackage{t.Name,&SBlock{rest,t.Pos},t.Pos})
+				return
+			}
+		default: stmts = append(stmts,e)
+		}
+	}
+	return
+}
+
 var end_of_file = parser.OR{
 	parser.RequireText{"__END__"},
 }
 func d_module(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
 	m := new(Module)
-	var stmts []interface{}
+	var elems []interface{}
 	pos := tokens.Pos
 	
 	for tokens!=nil {
@@ -81,21 +203,16 @@ func d_module(p *parser.Parser,tokens *scanlist.Element, left interface{}) parse
 		res := p.Match("Melem",tokens)
 		if !res.Ok() { return res }
 		tokens = res.Next
-		switch e := res.Data.(type) {
-		case *mDecl:
-			switch t := e.I.(type) {
-			case *MDSub: m.Subs = append(m.Subs,t)
-			}
-		default: stmts = append(stmts,e)
-		}
+		elems = append(elems,res.Data)
 	}
-	m.Main = &MDSub{"",&SBlock{stmts,pos},pos}
+	m.Main = &MDSub{"",&SBlock{m.collect("",elems),pos},pos,"",nil}
 	return parser.ResultOk(tokens,m)
 }
 
 func RegisterModule(p *parser.Parser) {
 	p.Define("Mdecl",false,parser.Pfunc(d_mdecl_sub))
 	p.Define("Mdecl",false,parser.Pfunc(d_mdecl_package))
+	p.Define("Mdecl",false,parser.Pfunc(d_mdecl_use))
 	
 	p.Define("Melem",false,parser.Pfunc(d_melem_mdecl))
 	p.Define("Melem",false,parser.Delegate("Stmt"))
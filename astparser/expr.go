@@ -74,12 +74,50 @@ func d_module_name(p *parser.Parser,tokens *scanlist.Element, left interface{})
 }
 var module_name_guess = parser.LSeq{parser.Pfunc(d_ident),require(':'),require(':')}
 
+/*
+Parses a variable or module name, which might be qualified ($Foo::Bar::x).
+A trailing "::name(" is not consumed, because it is a module call ($obj::method()).
+*/
+func d_var_ident(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
+	res := d_ident(p,tokens,left)
+	if !res.Ok() { return res }
+	name := res.Data.(string)
+	for {
+		ok,next := parser.FastMatch(res.Next,':',':')
+		if !ok { break }
+		sub := d_ident(p,next,nil)
+		if !sub.Ok() { break }
+		if ok,_ = parser.FastMatch(sub.Next,'('); ok { break }
+		name += "::"+sub.Data.(string)
+		res.Next = sub.Next
+	}
+	res.Data = name
+	return res
+}
+
+
+var vsspecial = parser.ArraySeq{require('{'),require('^'),parser.Pfunc(d_ident),require('}')}
+
+/*
+Parses the names of the match variables: $&, $-[n], $+[n], $+{name}, $-{name} and
+${^NAME} (${^PREMATCH}, ${^POSTMATCH}, the lexer rewrites $` and $' to those).
+*/
+func d_var_special(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
+	if tokens==nil { return parser.ResultFail("EOF!",scanner.Position{}) }
+	switch tokens.Token {
+	case '&','-','+': return parser.ResultOk(tokens.Next(),tokens.TokenText)
+	}
+	res := vsspecial.Parse(p,tokens,left)
+	if res.Ok() { res.Data = "^"+res.Data.([]interface{})[2].(string) }
+	return res
+}
 
 var vssigil = require('$')
 var vsprefix = parser.OR{
-	parser.Pfunc(d_ident),
+	parser.Pfunc(d_var_ident),
 	require(scanner.Int),
-	parser.Delegate("Vscalar"),
+	parser.Pfunc(d_vscalar_simple),
+	parser.Pfunc(d_var_special),
 	parser.ArraySeq{require('{'), parsex.Snip{parser.Delegate("Expr")}, parsex.Snip{require('}')}},
 }
 var vssuffix = parser.OR{
@@ -117,12 +155,65 @@ func d_vscalar(p *parser.Parser,tokens *scanlist.Element, left interface{}) pars
 	if !res1.Ok() { return parser.ResultOk(tokens, &EScalar{src, pos}) }
 	rl := res1.Data.([]interface{})
 	switch rl[0].(string) {
-	case "{":/*}*/ return parser.ResultOk(res1.Next, &EHashScalar{src, rl[1] ,pos})
-	case "[":/*]*/ return parser.ResultOk(res1.Next, &EArrayScalar{src, rl[1] ,pos})
+	case "{":/*}*/ return vsimplicit(p,res1.Next,&EHashScalar{src, rl[1] ,pos})
+	case "[":/*]*/ return vsimplicit(p,res1.Next,&EArrayScalar{src, rl[1] ,pos})
 	}
 	
 	return parser.ResultFail("Invalid Scalar Expression!",pos)
 }
+
+/*
+Applies subscripts with an implicit arrow ($x{a}[0] is $x{a}->[0]).
+*/
+func vsimplicit(p *parser.Parser,tokens *scanlist.Element, data interface{}) parser.ParserResult {
+	for {
+		if tokens==nil { break }
+		pos := tokens.Pos
+		res := vssuffix.Parse(p,tokens,nil)
+		if !res.Ok() { break }
+		rl := res.Data.([]interface{})
+		switch rl[0].(string) {
+		case "{":/*}*/ data = &EHashScalar{data, rl[1] ,pos}
+		case "[":/*]*/ data = &EArrayScalar{data, rl[1] ,pos}
+		}
+		tokens = res.Next
+	}
+	return parser.ResultOk(tokens,data)
+}
+
+/*
+Parses a scalar variable without subscripts ($x, $$x, $$$x).
+This is the operand of a dereference, so $$x[0] is ${$x}[0] and not ${$x[0]}.
+*/
+func d_vscalar_simple(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
+	if tokens==nil { return parser.ResultFail("EOF!",scanner.Position{}) }
+	pos := tokens.Pos
+	res := vssigil.Parse(p,tokens,nil)
+	if !res.Ok() { return res }
+	sub := d_var_ident(p,res.Next,nil)
+	if !sub.Ok() { sub = d_vscalar_simple(p,res.Next,nil) }
+	if sub.Ok() { sub.Data = &EScalar{sub.Data, pos} }
+	return sub
+}
+
+var vslastname = parser.OR{
+	parser.Pfunc(d_var_ident),
+	parser.Pfunc(d_vscalar_simple),
+	parser.ArraySeq{require('{'), parsex.Snip{parser.Delegate("Expr")}, parsex.Snip{require('}')}},
+}
+
+/*
+Parses the last index of an array: $#name, $#$ref or $#{expr}.
+*/
+func d_vscalar_last(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
+	ok,next := parser.FastMatch(tokens,'$','#')
+	if !ok { return parser.ResultFail("not matched",scanner.Position{}) }
+	res := vslastname.Parse(p,next,nil)
+	if !res.Ok() { return res }
+	if arr,ok := res.Data.([]interface{}); ok { res.Data = arr[1] }
+	res.Data = &ELastIndex{res.Data,tokens.Pos}
+	return res
+}
 var vscalarspec = parser.LSeq{require('$'),require('@')}
 func d_vscalarspec(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
 	res := vscalarspec.Parse(p,tokens,nil)
@@ -131,21 +222,81 @@ func d_vscalarspec(p *parser.Parser,tokens *scanlist.Element, left interface{})
 }
 
 
+func isASCII(s string) bool {
+	for i := 0; i<len(s); i++ {
+		if s[i]>=0x80 { return false }
+	}
+	return true
+}
+
 func d_literal(token *scanlist.Element) interface{} {
 	var lit values.Scalar = nil
 	if ok,_ := parser.FastMatch(token,KW_undef,':',':'); ok { return nil }
 	switch token.Token {
 	case KW_undef: lit = values.Null()
-	case scanner.Int: i,_ := strconv.ParseInt(token.TokenText, 0, 64); lit = values.ScInt(i)
-	case scanner.Float: f,_ := strconv.ParseFloat(token.TokenText, 64); lit = values.ScFloat(f)
+	case scanner.Int: lit = values.ParseInt(token.TokenText)
+	case scanner.Float: lit = values.ParseFloat(token.TokenText)
 	case scanner.Char,scanner.String,scanner.RawString: s,_ := strconv.Unquote(token.TokenText); lit = values.ScString(s)
 	}
 	if lit==nil { return nil }
-	return &ELiteral{lit, token.Pos}
+	return &ELiteral{lit, token.Pos, !isASCII(token.TokenText)}
+}
+
+var expr0_wantarray_kw = parser.RequireText{"wantarray"}
+func d_expr0_wantarray(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
+	res := expr0_wantarray_kw.Parse(p,tokens,left)
+	if !res.Ok() { return res }
+	if ok,next := parser.FastMatch(res.Next,'(',')'); ok { res.Next = next }
+	res.Data = &EWantArray{tokens.Pos}
+	return res
+}
+
+var expr0_exists_kw = parser.RequireText{"exists"}
+var expr0_exists_paren = parser.ArraySeq{require('('), parsex.Snip{parser.Delegate("Expr")}, parsex.Snip{require(')')}}
+func d_expr0_exists(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
+	res := expr0_exists_kw.Parse(p,tokens,left)
+	if !res.Ok() { return res }
+	if sub := expr0_exists_paren.Parse(p,res.Next,nil); sub.Ok() {
+		res = sub
+		res.Data = sub.Data.([]interface{})[1]
+	} else {
+		res = parsex.DoCut(p.Match("Expr1",res.Next))
+		if !res.Ok() { return res }
+	}
+	switch res.Data.(type) {
+	case *EHashScalar,*EArrayScalar:
+	default: return parser.ResultFail("exists argument is not a HASH or ARRAY element",tokens.Pos)
+	}
+	res.Data = &EExists{res.Data,tokens.Pos}
+	return res
+}
+
+var expr0_pos = parser.ArraySeq{parser.RequireText{"pos"}, require('('), parser.Delegate("Vscalar"), require(')')}
+var expr0_pos_short = parser.OR{
+	parser.ArraySeq{parser.RequireText{"pos"}, require('('), require(')')},
+	parser.ArraySeq{parser.RequireText{"pos"}, parser.Delegate("Vscalar")},
+	parser.RequireText{"pos"},
+}
+/*
+Parses pos($var), pos $var or pos (of $_).
+*/
+func d_expr0_pos(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
+	res := expr0_pos.Parse(p,tokens,nil)
+	if res.Ok() {
+		res.Data = &EPos{res.Data.([]interface{})[2],tokens.Pos}
+		return res
+	}
+	res = expr0_pos_short.Parse(p,tokens,nil)
+	if !res.Ok() { return res }
+	var v interface{} = &EScalar{"_",tokens.Pos}
+	if arr,ok := res.Data.([]interface{}); ok && len(arr)==2 { v = arr[1] }
+	res.Data = &EPos{v,tokens.Pos}
+	return res
 }
 
 func d_expr0_module_name(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
-	res := d_module_name(p,tokens,left)
+	// Foo::Bar::baz() is a call to baz in module Foo::Bar.
+	res := d_var_ident(p,tokens,left)
 	if res.Ok() { res.Data = &EModule{res.Data.(string),tokens.Pos} }
 	return res
 }
@@ -158,7 +309,14 @@ func d_expr0(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser
 	
 	switch tokens.Token {
 	case '+','-','!','~':{
-		sub := p.MatchNoLeftRecursion("Expr0",tokens.Next())
+		if op,ok := incdecOp(tokens); ok {
+			sub := d_incdec_operand(p,tokens.Next().Next())
+			if sub.Result==parser.RESULT_OK {
+				sub.Data = &EIncDec{op,sub.Data,false,tokens.Pos}
+			}
+			return sub
+		}
+		sub := d_postfix(p.MatchNoLeftRecursion("Expr0",tokens.Next()))
 		if sub.Result==parser.RESULT_OK {
 			sub.Data = &EUnop{tokens.TokenText,sub.Data,tokens.Pos}
 		}
@@ -236,6 +394,38 @@ func d_expr0_ref(p *parser.Parser,tokens *scanlist.Element, left interface{}) pa
 	return res
 }
 
+var qw_open = parser.LSeq{parser.RequireText{"qw"},require('(')}
+
+/*
+Parses qw(...) into a []string. As the scanner drops the whitespace, adjacent tokens
+are glued together, so that qw($x a-b) yields "$x" and "a-b".
+*/
+func d_qw(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
+	res := qw_open.Parse(p,tokens,nil)
+	if !res.Ok() { return res }
+	words := []string{}
+	var prev *scanlist.Element
+	for t := res.Next; t!=nil; t = t.Next() {
+		if t.Token==')' { return parser.ResultOk(t.Next(),words) }
+		if prev!=nil && prev.Pos.Offset+len(prev.TokenText)==t.Pos.Offset {
+			words[len(words)-1] += t.TokenText
+		} else {
+			words = append(words,t.TokenText)
+		}
+		prev = t
+	}
+	return parsex.DoCut(parser.ResultFail("unterminated qw(...)",tokens.Pos))
+}
+func d_expr0_qw(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
+	res := d_qw(p,tokens,left)
+	if !res.Ok() { return res }
+	words := res.Data.([]string)
+	elems := make([]interface{},len(words))
+	for i,w := range words { elems[i] = &ELiteral{values.ScString(w),tokens.Pos,!isASCII(w)} }
+	res.Data = &AConcat{elems,tokens.Pos}
+	return res
+}
+
 var va_subcall = parser.OR{
 	parser.ArraySeq{ require(scanner.Ident),require('('),require(')') },
 	parser.ArraySeq{ require(scanner.Ident),require('('),parsex.Snip{vsexlist},parsex.Snip{require(')')} },
@@ -267,6 +457,16 @@ var vbinop_simple = parser.OR{
 	require('/'),
 	require('%'),
 	require('.'),
+	require('&'),
+	require('|'),
+	require('^'),
+}
+// The operators, that have a compound assignment form (op=).
+var vbinop_assignable = parser.OR{
+	parser.ArraySeq{require('*'),require('*')},
+	parser.ArraySeq{require('<'),require('<')},
+	parser.ArraySeq{require('>'),require('>')},
+	parser.ArraySeq{vbinop_simple},
 }
 
 var vbinop_single = parser.OR{
@@ -279,21 +479,68 @@ var vbinop_single = parser.OR{
 	require(KW_gt),
 	require(KW_le),
 	require(KW_lt),
+	require(KW_cmp),
 	require('<'),
 	require('>'),
 }
 var vbinop = parser.OR{
+	parser.ArraySeq{require('<'),require('='),require('>')},
 	parser.ArraySeq{require('<'),require('=')},
 	parser.ArraySeq{require('>'),require('=')},
 	parser.ArraySeq{require('='),require('=')},
 	parser.ArraySeq{require('!'),require('=')},
+	parser.ArraySeq{require('*'),require('*')},
+	parser.ArraySeq{require('<'),require('<')},
+	parser.ArraySeq{require('>'),require('>')},
 	parser.ArraySeq{vbinop_single},
 }
 
+// Returns "++" or "--", if the tokens start with one (without space in between).
+func incdecOp(tokens *scanlist.Element) (string,bool) {
+	next := tokens.Next()
+	if next==nil || next.Token!=tokens.Token || next.Pos.Offset!=tokens.Pos.Offset+1 { return "",false }
+	switch tokens.Token {
+	case '+': return "++",true
+	case '-': return "--",true
+	}
+	return "",false
+}
+
+// Applies a postfix ++ or -- to the operand in res, which binds tighter than any operator.
+func d_postfix(res parser.ParserResult) parser.ParserResult {
+	if !res.Ok() || res.Next==nil { return res }
+	if op,ok := incdecOp(res.Next); ok {
+		res.Data = &EIncDec{op,res.Data,true,res.Next.Pos}
+		res.Next = res.Next.Next().Next()
+	}
+	return res
+}
+
+/*
+Parses the operand of a prefix ++ or --. Arrows bind tighter, so ++$r->{x} increments the
+hash element, not $r.
+*/
+func d_incdec_operand(p *parser.Parser,tokens *scanlist.Element) parser.ParserResult {
+	res := p.MatchNoLeftRecursion("Expr0",tokens)
+	for res.Ok() && res.Next!=nil {
+		arrow := d_expr1_arrow(p,res.Next,res.Data)
+		if arrow.Result==parser.RESULT_FAILED { break }
+		res = arrow
+	}
+	return res
+}
+
+func d_expr_postfix(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
+	if tokens==nil { return parser.ResultFail("EOF!",scanner.Position{}) }
+	if _,ok := incdecOp(tokens); !ok { return parser.ResultFail("Expected ++ or --",tokens.Pos) }
+	return d_postfix(parser.ResultOk(tokens,left))
+}
+
 func d_expr0_trailer(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
 	if tokens==nil { return parser.ResultFail("EOF!",scanner.Position{}) }
 	
 	if ok,_ := parser.FastMatch(tokens,'-','>'); ok { return parsex.Jump() }
+	if _,ok := incdecOp(tokens); ok { return parsex.Jump() }
 	
 	pos := tokens.Pos
 	
@@ -303,7 +550,10 @@ func d_expr0_trailer(p *parser.Parser,tokens *scanlist.Element, left interface{}
 	
 	op := fmt.Sprint(res1.Data.([]interface{})...)
 	
-	res1 = parsex.DoCut(p.MatchNoLeftRecursion("Expr0",tokens))
+	// op= is a compound assignment (Expr3).
+	if ok,_ := parser.FastMatch(tokens,'='); ok { return parsex.Jump() }
+	
+	res1 = parsex.DoCut(d_postfix(p.MatchNoLeftRecursion("Expr0",tokens)))
 	
 	if res1.Ok() {
 		res1.Data = &EBinop{op,left,res1.Data,pos}
@@ -318,34 +568,104 @@ var rxlit = parser.OR{
 	require(scanner.RawString),
 }
 
+var rxbind = parser.OR{
+	parser.ArraySeq{require('='),require('~')},
+	parser.ArraySeq{require('!'),require('~')},
+}
+// The replacement of s///: an expression or {expression}, which ends before the modifiers.
+var rxrepl = parser.OR{
+	parser.ArraySeq{require('{'), parsex.Snip{parser.Delegate("Expr")}, parsex.Snip{require('}')}},
+	parsex.DelegateShort("Expr1"),
+}
 var rxtrail = parser.OR{
-	parser.ArraySeq{require('='),require('~'),require(KW_m),rxlit},
-	parser.ArraySeq{require('='),require('~'),require(KW_s),rxlit,parsex.DelegateShort("Expr1")},
+	parser.ArraySeq{rxbind,require(KW_m),rxlit},
+	parser.ArraySeq{rxbind,require(KW_s),rxlit,rxrepl},
+	parser.ArraySeq{rxbind,require(KW_tr),rxlit,rxlit},
+	parser.ArraySeq{rxbind,parsex.DelegateShort("Expr1")},
+}
+
+/*
+The text of a pattern, or of a SEARCH or REPLACE list. The lexer quotes it as "...", if it
+contains a ` or a carriage return.
+*/
+func rxtext(tok string) string {
+	if tok[0]=='"' {
+		if s,err := strconv.Unquote(tok); err==nil { return s }
+	}
+	return tok[1:len(tok)-1]
+}
+
+var rxmods_m = regexp.MustCompile(`^[imsxgb]+$`)
+var rxmods_s = regexp.MustCompile(`^[imsxgerb]+$`)
+var rxmods_tr = regexp.MustCompile(`^[cdsr]+$`)
+
+// Parses the modifiers following a regex (m "..." gi).
+func d_rxmods(p *parser.Parser,tokens *scanlist.Element, valid *regexp.Regexp) (string,*scanlist.Element) {
+	if fl := d_ident(p,tokens,nil); fl.Ok() && valid.MatchString(fl.Data.(string)) {
+		return fl.Data.(string),fl.Next
+	}
+	return "",tokens
 }
 
 func d_expr1_trailer(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
 	res := rxtrail.Parse(p,tokens,nil)
 	if !res.Ok() { return res }
 	rxx := res.Data.([]interface{})
-	rest := res.Next
-	
-	rxs := rxx[3].(string)
-	rxs = rxs[1:len(rxs)-1]
-	rx,err := regexp.Compile(rxs)
-	if err!=nil { return parser.ResultFail("Invalid regex: "+err.Error(),tokens.Pos) }
-	
-	
-	switch rxx[2].(string) {
-	case "m":
-		if rest.SafeTokenText()=="g" {
-			res.Next = rest.Next()
-			res.Data = &EMatchGlobal{left,rx,tokens.Pos}
+	neg := rxx[0].([]interface{})[0].(string)=="!"
+	
+	if len(rxx)==2 {
+		// $str =~ $re
+		res.Data = &EMatch{left,nil,tokens.Pos,rxx[1]}
+	} else if rxx[1].(string)=="tr" {
+		var flags string
+		flags,res.Next = d_rxmods(p,res.Next,rxmods_tr)
+		tr,err := values.NewTrans(rxtext(rxx[2].(string)),rxtext(rxx[3].(string)),flags)
+		if err!=nil { return parser.ResultFail(err.Error(),tokens.Pos) }
+		res.Data = &ETrans{left,tr,tokens.Pos,flags}
+	} else {
+		var flags string
+		if rxx[1].(string)=="m" {
+			flags,res.Next = d_rxmods(p,res.Next,rxmods_m)
 		} else {
-			res.Data = &EMatch{left,rx,tokens.Pos}
+			flags,res.Next = d_rxmods(p,res.Next,rxmods_s)
+		}
+		
+		rx,err := values.CompilePattern(rxtext(rxx[2].(string)),flags)
+		if err!=nil { return parser.ResultFail("Invalid regex: "+err.Error(),tokens.Pos) }
+		
+		switch {
+		case rxx[1].(string)=="s":
+			repl := rxx[3]
+			if arr,ok := repl.([]interface{}); ok { repl = arr[1] }
+			res.Data = &EReplace{left,rx,repl,tokens.Pos,flags}
+		case strings.ContainsRune(flags,'g'):
+			res.Data = &EMatchGlobal{left,rx,tokens.Pos}
+		default:
+			res.Data = &EMatch{left,rx,tokens.Pos,nil}
 		}
-	case "s":
-		res.Data = &EReplace{left,rx,rxx[4],tokens.Pos}
 	}
+	
+	// $str !~ m/.../
+	if neg { res.Data = &EUnop{"!",res.Data,tokens.Pos} }
+	return res
+}
+
+var qrflags = regexp.MustCompile(`^[imsxb]+$`)
+
+/*
+Parses a compiled regex: qr "pattern" or qr "pattern" flags.
+The pattern is enclosed in (?flags:...), so it can be interpolated into larger patterns.
+*/
+func d_expr0_qr(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
+	ok,next := parser.FastMatch(tokens,KW_qr)
+	if !ok { return parser.ResultFail("not matched",scanner.Position{}) }
+	res := parsex.DoCut(rxlit.Parse(p,next,nil))
+	if !res.Ok() { return res }
+	var flags string
+	flags,res.Next = d_rxmods(p,res.Next,qrflags)
+	rx,err := values.CompilePattern(rxtext(res.Data.(string)),flags)
+	if err!=nil { return parsex.DoCut(parser.ResultFail("Invalid regex: "+err.Error(),tokens.Pos)) }
+	res.Data = &ERegex{rx,tokens.Pos}
 	return res
 }
 
@@ -355,6 +675,9 @@ var oparrow = parser.OR{
 	parser.ArraySeq{require('{'), parser.Pfunc(d_ident), require('}')},
 	parser.ArraySeq{require('{'), parsex.Snip{parser.Delegate("Expr")}, parsex.Snip{require('}')}},
 	parser.ArraySeq{require('['), parsex.Snip{parser.Delegate("Expr")}, parsex.Snip{require(']')}},
+	parser.ArraySeq{require('@'), require('*')},
+	parser.ArraySeq{require('%'), require('*')},
+	parser.ArraySeq{require('$'), require('#'), require('*')},
 }
 
 func d_expr1_arrow(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
@@ -371,8 +694,11 @@ func d_expr1_arrow(p *parser.Parser,tokens *scanlist.Element, left interface{})
 	str := arr[0].(string)
 	
 	switch str {
-	case "{":/*}*/ res.Data = &EHashScalar{left, arr[1] ,pos}
-	case "[":/*]*/ res.Data = &EArrayScalar{left, arr[1] ,pos}
+	case "{":/*}*/ return vsimplicit(p,res.Next,&EHashScalar{left, arr[1] ,pos})
+	case "[":/*]*/ return vsimplicit(p,res.Next,&EArrayScalar{left, arr[1] ,pos})
+	case "@": res.Data = &AArray{left,pos}
+	case "%": res.Data = &AHash{left,pos}
+	case "$": res.Data = &ELastIndex{left,pos}
 	default:
 		call := &EObjCall{left,str,nil,pos}
 		if len(arr)==4 { call.Args = arr[2].([]interface{}) }
@@ -456,20 +782,23 @@ func d_expr3_trailer1(p *parser.Parser,tokens *scanlist.Element, left interface{
 }
 
 
-var opassign = parser.ArraySeq{vbinop_simple,require('='),parser.Delegate("Expr3")}
+var opassign = parser.ArraySeq{vbinop_assignable,require('='),parser.Delegate("Expr3")}
 
 func d_expr3_trailer2(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
 	res := opassign.Parse(p,tokens,nil)
 	if !res.Ok() { return res }
-	res.Data = &EBinopAssign{res.Data.([]interface{})[0].(string),left,res.Data.([]interface{})[2],tokens.Pos}
+	list := res.Data.([]interface{})
+	res.Data = &EBinopAssign{fmt.Sprint(list[0].([]interface{})...),left,list[2],tokens.Pos}
 	return res
 }
 
 var vasigil = parser.OR{ require('@'), require('%') }
 var vaname = parser.OR{
-	parser.Pfunc(d_ident),
+	parser.Pfunc(d_var_ident),
 	require(scanner.Int),
-	parser.Delegate("Vscalar"),
+	parser.Pfunc(d_vscalar_simple),
+	require('-'), // @-, %-
+	require('+'), // @+, %+
 	parser.ArraySeq{require('{'), parsex.Snip{parser.Delegate("Expr")}, parsex.Snip{require('}')}},
 }
 var vacomplete = parser.ArraySeq{ vasigil,vaname }
@@ -490,21 +819,30 @@ func d_array_variable(p *parser.Parser, tokens *scanlist.Element, left interface
 
 func RegisterExpr(p *parser.Parser) {
 
+	p.Define("Vscalar",false,parser.Pfunc(d_vscalar_last))
 	p.Define("Vscalar",false,parser.Pfunc(d_vscalar))
 	p.Define("Vscalar",false,parser.Pfunc(d_vscalarspec))
 	p.Define("Expr0",false,parser.Delegate("Vscalar"))
 	p.Define("Expr0",false,parser.Pfunc(d_array_variable))
+	p.Define("Expr0",false,parser.Pfunc(d_expr0_my))
+	p.Define("Expr0",false,parser.Pfunc(d_expr0_wantarray))
+	p.Define("Expr0",false,parser.Pfunc(d_expr0_exists))
+	p.Define("Expr0",false,parser.Pfunc(d_expr0_qr))
+	p.Define("Expr0",false,parser.Pfunc(d_expr0_pos))
 	p.Define("Expr0",false,parser.Pfunc(d_expr0))
 	
 	p.Define("Expr0",false,parser.Pfunc(d_expr0_ref))
+	p.Define("Expr0",false,parser.Pfunc(d_expr0_qw))
 	p.Define("Expr0",false,parser.Pfunc(d_expr0_call))
 	p.Define("Expr0",false,parser.Pfunc(d_expr0_module_name))
+	p.Define("Expr0",true,parser.Pfunc(d_expr_postfix))
 	p.Define("Expr0",true,parser.Pfunc(d_expr0_trailer))
 	
 	p.Define("Expr1",false,parser.Delegate("Expr0"))
 	p.Define("Expr1",true,parser.Pfunc(d_expr1_trailer))
 	p.Define("Expr1",true,parser.Pfunc(d_expr1_arrow))
 	p.Define("Expr1",true,parser.Pfunc(d_expr1_modcall))
+	p.Define("Expr1",true,parser.Pfunc(d_expr_postfix))
 	
 	p.Define("Expr2",false,parser.Delegate("Expr1"))
 	p.Define("Expr2",true,parser.Pfunc(d_expr2_go))
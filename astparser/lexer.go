@@ -0,0 +1,409 @@
+/*
+Copyright (c) 2021 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package astparser
+
+import "bytes"
+import "strconv"
+import "strings"
+
+/*
+Regex literals can't be scanned with text/scanner, which knows Go tokens only. So before
+the source is scanned, they are rewritten into the token syntax, the parser understands:
+
+	m/a\/b/gi         ->  m `a/b` gi
+	qr{x}i            ->  qr `x` i
+	s/(\d+)/<$1>/g    ->  s `(\d+)` {"<" . $1 . ">"} g
+	s{(\d+)}{$1*2}e   ->  s `(\d+)` {$1*2} e
+	y/a-z/A-Z/r       ->  tr `a-z` `A-Z` r
+	$x =~ /pat/       ->  $x =~ m `pat`
+	/pat/             ->  ($_ =~ m `pat`)
+	$` and $'         ->  ${^PREMATCH} and ${^POSTMATCH}
+
+Whether a "/" starts a regex or is a division depends on the previous token, as in Perl.
+A "//" is a comment, except directly after =~ or !~. Patterns with Go quotes (m"...") are
+left as they are. The line structure is retained, so positions stay valid.
+*/
+func RewriteLiterals(src []byte) []byte {
+	l := &rxlexer{src:src}
+	l.run()
+	return l.out.Bytes()
+}
+
+// Words, after which a "/" starts a regex, rather than a division.
+var rxOperatorWords = map[string]bool{
+	"and":true, "or":true, "not":true, "if":true, "unless":true, "while":true, "until":true,
+	"return":true, "print":true, "eq":true, "ne":true, "lt":true, "gt":true, "le":true, "ge":true, "cmp":true,
+}
+
+type rxlexer struct{
+	src []byte
+	i int
+	out bytes.Buffer
+	term bool // the previous token ends a term, so "/" is a division
+	bind bool // the previous token is =~ or !~
+	name bool // the previous token is a sigil, -> or ::, so the next word is a name
+}
+
+func (l *rxlexer) peek(n int) byte {
+	if l.i+n<len(l.src) { return l.src[l.i+n] }
+	return 0
+}
+func isIdentByte(c byte) bool {
+	return c=='_' || c>='a'&&c<='z' || c>='A'&&c<='Z' || c>='0'&&c<='9' || c>=0x80
+}
+func isSpaceByte(c byte) bool {
+	return c==' ' || c=='\t' || c=='\n' || c=='\r' || c=='\f' || c=='\v'
+}
+func (l *rxlexer) copyTo(j int) {
+	l.out.Write(l.src[l.i:j])
+	l.i = j
+}
+// $` and $' can't be scanned either, they are rewritten to ${^PREMATCH} and ${^POSTMATCH}.
+func (l *rxlexer) matchVariable() {
+	switch l.peek(1) {
+	case '`': l.out.WriteString("${^PREMATCH}")
+	case '\'': l.out.WriteString("${^POSTMATCH}")
+	default: l.out.WriteString("$&")
+	}
+	l.i += 2
+}
+// Copies a quoted string, char or raw string.
+func (l *rxlexer) copyQuoted() {
+	q := l.src[l.i]
+	j := l.i+1
+	for j<len(l.src) && l.src[j]!=q {
+		if l.src[j]=='\\' && q!='`' { j++ }
+		j++
+	}
+	if j<len(l.src) { j++ }
+	l.copyTo(j)
+}
+
+func (l *rxlexer) run() {
+	for l.i<len(l.src) {
+		c := l.src[l.i]
+		switch {
+		case isSpaceByte(c):
+			l.copyTo(l.i+1)
+			continue
+		case c=='/' && l.peek(1)=='/' && !l.bind:
+			j := bytes.IndexByte(l.src[l.i:],'\n')
+			if j<0 { j = len(l.src) } else { j += l.i }
+			l.copyTo(j)
+			continue
+		case c=='/' && l.peek(1)=='*':
+			j := bytes.Index(l.src[l.i+2:],[]byte("*/"))
+			if j<0 { j = len(l.src) } else { j += l.i+4 }
+			l.copyTo(j)
+			continue
+		}
+		
+		bind,name := l.bind,l.name
+		l.bind,l.name = false,false
+		switch {
+		case c=='"' || c=='\'' || c=='`':
+			l.copyQuoted()
+			l.term = true
+		case c>='0' && c<='9':
+			j := l.i
+			for j<len(l.src) && (isIdentByte(l.src[j]) || l.src[j]=='.') { j++ }
+			l.copyTo(j)
+			l.term = true
+		case isIdentByte(c):
+			j := l.i
+			for j<len(l.src) && isIdentByte(l.src[j]) { j++ }
+			word := string(l.src[l.i:j])
+			if !name && l.literal(word,j,bind) { continue }
+			l.copyTo(j)
+			l.term = name || !rxOperatorWords[word]
+		case c=='/' && (bind || !l.term) && l.literal("",l.i,bind):
+		case c=='$' && (l.peek(1)=='`' || l.peek(1)=='\'' || l.peek(1)=='&'):
+			l.matchVariable()
+			l.term = true
+		case (c=='$' || c=='@' || c=='%' || c=='&') && l.peek(1)!=c:
+			l.copyTo(l.i+1)
+			l.name = true
+			l.term = false
+		default:
+			two := string(l.src[l.i:l.i+1])+string(l.peek(1))
+			switch two {
+			case "=~","!~":
+				l.copyTo(l.i+2)
+				l.bind = true
+			case "&&","||","++","--":
+				l.copyTo(l.i+2)
+			case "->","::":
+				l.copyTo(l.i+2)
+				l.name = true
+			default:
+				l.copyTo(l.i+1)
+			}
+			l.term = c==')' || c==']' || c=='}' || two=="++" || two=="--" // $i++ / 2
+		}
+	}
+}
+
+/*
+Checks for a delimiter at j (after whitespace). Quotes are not delimiters, as m"..." is
+the token syntax of the parser.
+*/
+func (l *rxlexer) delimiter(j int) (int,bool) {
+	for j<len(l.src) && isSpaceByte(l.src[j]) { j++ }
+	if j>=len(l.src) { return j,false }
+	c := l.src[j]
+	if isIdentByte(c) || c>=0x80 { return j,false }
+	switch c {
+	case '"','\'','`', ',',';','=',')',']','}','>':
+		return j,false
+	}
+	return j,true
+}
+
+func closingDelimiter(c byte) byte {
+	switch c {
+	case '(': return ')'
+	case '[': return ']'
+	case '{': return '}'
+	case '<': return '>'
+	}
+	return c
+}
+
+/*
+Reads a delimited section, starting with the opening delimiter at j. Brackets nest.
+A backslash before the delimiter is removed, unless the delimiter is a regex metacharacter.
+*/
+func (l *rxlexer) section(j int) (string,int,bool) {
+	open := l.src[j]
+	end := closingDelimiter(open)
+	meta := strings.IndexByte(`\^$.|?*+()[]{}`,open)>=0
+	var b strings.Builder
+	depth := 0
+	for j++; j<len(l.src); j++ {
+		c := l.src[j]
+		switch {
+		case c=='\\' && j+1<len(l.src):
+			j++
+			if (l.src[j]!=open && l.src[j]!=end) || meta { b.WriteByte(c) }
+			c = l.src[j]
+		case c==end && depth==0:
+			return b.String(),j+1,true
+		case c==end:
+			depth--
+		case c==open && open!=end:
+			depth++
+		}
+		b.WriteByte(c)
+	}
+	return "",j,false
+}
+
+func (l *rxlexer) flags(j int) (string,int) {
+	k := j
+	for k<len(l.src) && (l.src[k]>='a' && l.src[k]<='z') { k++ }
+	return string(l.src[j:k]),k
+}
+
+// Quotes a pattern as raw string, if possible, to keep its line breaks.
+func quotePattern(s string) string {
+	if strings.ContainsAny(s,"`\r") { return strconv.Quote(s) }
+	return "`"+s+"`"
+}
+
+/*
+Rewrites a regex literal at j (after the word m, qr, s, tr or y; or at a bare "/"). Returns false,
+if there is none.
+*/
+func (l *rxlexer) literal(word string, j int, bind bool) bool {
+	switch word {
+	case "","m","qr","s","tr","y":
+	default: return false
+	}
+	var ok bool
+	if word!="" {
+		if j,ok = l.delimiter(j); !ok { return false }
+	}
+	var pat,repl,flags string
+	k := j
+	pat,k,ok = l.section(k)
+	if !ok { return false }
+	if word=="s" || word=="tr" || word=="y" {
+		if open := l.src[j]; closingDelimiter(open)!=open {
+			// s{...}{...}
+			if k,ok = l.delimiter(k); !ok { return false }
+		} else {
+			k--
+		}
+		repl,k,ok = l.section(k)
+		if !ok { return false }
+	}
+	flags,k = l.flags(k)
+	
+	var b strings.Builder
+	switch word {
+	case "","m":
+		if !bind { b.WriteString("($_ =~ ") }
+		b.WriteString("m ")
+		b.WriteString(quotePattern(pat))
+	case "qr":
+		b.WriteString("qr ")
+		b.WriteString(quotePattern(pat))
+	case "s":
+		if !bind { b.WriteString("($_ =~ ") }
+		b.WriteString("s ")
+		b.WriteString(quotePattern(pat))
+		b.WriteString(" {")
+		if strings.ContainsRune(flags,'e') {
+			b.Write(RewriteLiterals([]byte(repl)))
+		} else {
+			b.WriteString(interpolate(repl))
+		}
+		b.WriteString("}")
+	case "tr","y":
+		if !bind { b.WriteString("($_ =~ ") }
+		b.WriteString("tr ")
+		b.WriteString(quotePattern(pat))
+		b.WriteString(" ")
+		b.WriteString(quotePattern(repl))
+	}
+	if flags!="" {
+		b.WriteString(" ")
+		b.WriteString(flags)
+	}
+	if !bind && word!="qr" { b.WriteString(")") }
+	
+	// Keep the line structure.
+	for n := bytes.Count(l.src[l.i:k],[]byte("\n"))-strings.Count(b.String(),"\n"); n>0; n-- {
+		b.WriteByte('\n')
+	}
+	l.out.WriteString(b.String())
+	l.i = k
+	l.term = true
+	return true
+}
+
+/*
+Translates the replacement of s/// into an expression: "a$1b" becomes "a" . $1 . "b".
+Variables are $name, ${name} and $1, $2, ..., as in Perl, $name may be followed by subscripts
+($h{k}, $a[0], $r->[0]{k}, ...). Arrays (@name) are joined with " ".
+*/
+func interpolate(s string) string {
+	var parts []string
+	var lit strings.Builder
+	flush := func() {
+		if lit.Len()==0 { return }
+		parts = append(parts,strconv.Quote(lit.String()))
+		lit.Reset()
+	}
+	for i := 0; i<len(s); i++ {
+		c := s[i]
+		if c=='\\' && i+1<len(s) {
+			i++
+			switch c = s[i]; c {
+			case 'n': c = '\n'
+			case 't': c = '\t'
+			case 'r': c = '\r'
+			case 'f': c = '\f'
+			case 'e': c = 0x1b
+			case '0': c = 0
+			}
+			lit.WriteByte(c)
+			continue
+		}
+		if c=='@' && i+1<len(s) && isIdentByte(s[i+1]) && !(s[i+1]>='0' && s[i+1]<='9') {
+			j := identEnd(s,i+1)
+			flush()
+			parts = append(parts,"join(\" \", "+s[i:j]+")")
+			i = j-1
+			continue
+		}
+		if c!='$' || i+1==len(s) {
+			lit.WriteByte(c)
+			continue
+		}
+		j := i+1
+		var name string
+		named := false // $name, that may have subscripts
+		switch {
+		case s[j]=='{':
+			k := strings.IndexByte(s[j:],'}')
+			if k<0 { lit.WriteByte(c); continue }
+			name = s[j+1:j+k]
+			j += k+1
+		case s[j]>='0' && s[j]<='9':
+			for j<len(s) && s[j]>='0' && s[j]<='9' { j++ }
+			name = s[i+1:j]
+		case s[j]=='&':
+			name,j = "&",j+1
+		case s[j]=='`':
+			name,j = "{^PREMATCH}",j+1
+		case s[j]=='\'':
+			name,j = "{^POSTMATCH}",j+1
+		case isIdentByte(s[j]):
+			j = identEnd(s,j)
+			name,named = s[i+1:j],true
+		default:
+			lit.WriteByte(c)
+			continue
+		}
+		flush()
+		if k := subscriptsEnd(s,j); named && k>j {
+			// "->" after a binary operator needs parentheses.
+			parts = append(parts,"("+s[i:k]+")")
+			i = k-1
+			continue
+		}
+		parts = append(parts,"$"+name)
+		i = j-1
+	}
+	flush()
+	if len(parts)==0 { return `""` }
+	return strings.Join(parts," . ")
+}
+// The end of the (possibly qualified) name at s[j:].
+func identEnd(s string, j int) int {
+	for j<len(s) && (isIdentByte(s[j]) || (s[j]==':' && j+2<len(s) && s[j+1]==':' && isIdentByte(s[j+2]))) {
+		if s[j]==':' { j++ }
+		j++
+	}
+	return j
+}
+// The end of the subscripts ([...], {...}, ->[...] and ->{...}) at s[j:], or j if there are none.
+func subscriptsEnd(s string, j int) int {
+	for {
+		k := j
+		if strings.HasPrefix(s[k:],"->") { k += 2 }
+		if k>=len(s) || (s[k]!='[' && s[k]!='{') { return j }
+		depth := 0
+		for ; k<len(s); k++ {
+			switch s[k] {
+			case '[','{': depth++
+			case ']','}': depth--
+			}
+			if depth==0 { break }
+		}
+		if k>=len(s) { return j }
+		j = k+1
+	}
+}
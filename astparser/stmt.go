@@ -56,6 +56,31 @@ func d_declvarlist(p *parser.Parser,tokens *scanlist.Element, left interface{})
 
 var declMy = parser.ArraySeq{require(KW_my), parser.Pfunc(d_declvarlist), require(';')}
 
+var exprMyList = parser.ArraySeq{require('('), parsex.Snip{parser.Pfunc(d_declvarlist)}, parsex.Snip{require(')')}}
+
+/*
+Parses "my" as an expression: my $x is a scalar, my @x, my %x and my (...) are lists.
+*/
+func d_expr0_my(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
+	if tokens==nil { return parser.ResultFail("EOF!",scanner.Position{}) }
+	if tokens.Token!=KW_my { return parser.ResultFail("Invalid my Expression!",tokens.Pos) }
+	
+	if res := exprMyList.Parse(p,tokens.Next(),nil); res.Ok() {
+		res.Data = &AMy{res.Data.([]interface{})[1].([]interface{}),tokens.Pos}
+		return res
+	}
+	
+	res := parsex.DoCut(declVarSingle.Parse(p,tokens.Next(),nil))
+	if !res.Ok() { return res }
+	v := fmt.Sprint(res.Data.([]interface{})...)
+	if v[0]=='$' {
+		res.Data = &EMy{v,tokens.Pos}
+	} else {
+		res.Data = &AMy{[]interface{}{v},tokens.Pos}
+	}
+	return res
+}
+
 func d_decl_myvar(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
 	if tokens==nil { return parser.ResultFail("EOF!",scanner.Position{}) }
 	res := declMy.Parse(p,tokens,left)
@@ -66,10 +91,26 @@ func d_decl_myvar(p *parser.Parser,tokens *scanlist.Element, left interface{}) p
 	return res
 }
 
+var declOur = parser.ArraySeq{require(KW_our), parser.Pfunc(d_declvarlist), require(';')}
+
+func d_decl_ourvar(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
+	if tokens==nil { return parser.ResultFail("EOF!",scanner.Position{}) }
+	res := declOur.Parse(p,tokens,left)
+	if !res.Ok() { return res }
+	
+	res.Data = &SOurVars{res.Data.([]interface{})[1].([]interface{}),tokens.Pos}
+	
+	return res
+}
+
 func d_stmtsub_expr(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
 	if declMy.Parse(p,tokens,left).Ok() { return parsex.Jump() }
+	if declOur.Parse(p,tokens,left).Ok() { return parsex.Jump() }
 	if stmtsub_print.Parse(p,tokens,left).Ok() { return parsex.Jump() }
 	if stmtsub_do.Parse(p,tokens,left).Ok() { return parsex.Jump() }
+	if stmtsub_require_kw.Parse(p,tokens,left).Ok() { return parsex.Jump() }
+	if stmtsub_return_kw.Parse(p,tokens,left).Ok() { return parsex.Jump() }
+	if stmtsub_loopjmp.Parse(p,tokens,left).Ok() { return parsex.Jump() }
 	if stmt_block_o.Parse(p,tokens,left).Ok() { return parsex.Jump() }
 	
 	res := p.Match("Expr",tokens)
@@ -98,13 +139,58 @@ func d_stmtsub_print(p *parser.Parser,tokens *scanlist.Element, left interface{}
 	return res
 }
 
+var stmtsub_require_kw = parser.RequireText{"require"}
+func d_stmtsub_require(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
+	res := stmtsub_require_kw.Parse(p,tokens,left)
+	if !res.Ok() { return res }
+	
+	// require Foo::Bar;
+	if mn := d_module_name(p,res.Next,nil); mn.Ok() {
+		if ok,_ := parser.FastMatch(mn.Next,';'); ok {
+			mn.Data = &SRequireStatic{mn.Data.(string),tokens.Pos}
+			return mn
+		}
+	}
+	
+	// require $name;
+	res = parsex.DoCut(p.Match("Expr",res.Next))
+	if res.Ok() { res.Data = &SRequireDynamic{res.Data,tokens.Pos} }
+	return res
+}
+
+var stmtsub_return_kw = parser.RequireText{"return"}
+func d_stmtsub_return(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
+	res := stmtsub_return_kw.Parse(p,tokens,left)
+	if !res.Ok() { return res }
+	
+	// return;
+	if ok,_ := parser.FastMatch(res.Next,';'); ok {
+		res.Data = &SReturn{nil,tokens.Pos}
+		return res
+	}
+	
+	res = parsex.DoCut(p.Match("Expr",res.Next))
+	if res.Ok() { res.Data = &SReturn{res.Data,tokens.Pos} }
+	return res
+}
+
 var stmtsub_loopjmp = parser.OR{
 	parser.RequireText{"next"},
 	parser.RequireText{"last"},
+	parser.RequireText{"redo"},
 }
+var stmtsub_loopjmp_label = require(scanner.Ident)
 func d_stmtsub_loopjmp(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
 	res := stmtsub_loopjmp.Parse(p,tokens,left)
-	if res.Ok() { res.Data = &SLoopJump{res.Data.(string),tokens.Pos} }
+	if !res.Ok() { return res }
+	jmp := &SLoopJump{res.Data.(string),tokens.Pos,""}
+	
+	// next LABEL;
+	if lbl := stmtsub_loopjmp_label.Parse(p,res.Next,nil); lbl.Ok() {
+		jmp.Label = lbl.Data.(string)
+		res.Next = lbl.Next
+	}
+	res.Data = jmp
 	return res
 }
 
@@ -119,7 +205,7 @@ func d_stmtsub_cond(p *parser.Parser,tokens *scanlist.Element, left interface{})
 	r := res.Data.([]interface{})
 	op := r[0].(string)
 	if op=="for" {
-		res.Data = &SFor{"_",r[1],left,tokens.Pos}
+		res.Data = &SFor{"_",r[1],left,tokens.Pos,false}
 	} else {
 		res.Data = &SCond{op,r[1],left,tokens.Pos}
 	}
@@ -166,14 +252,15 @@ func d_stmt_cond(p *parser.Parser,tokens *scanlist.Element, left interface{}) pa
 	if !res.Ok() { return res }
 	r := res.Data.([]interface{})
 	op := r[0].(string)
+	pos := tokens.Pos
 	tokens = res.Next
 	if possibleElse[op] && stmt_cond_haselse.Parse(p,tokens,nil).Ok() {
 		res2 := stmt_cond_suffix.Parse(p,tokens,nil)
 		if !res2.Ok() { return res2 }
-		res.Data = &SIfElse{op, r[2], r[4], res2.Data, tokens.Pos}
+		res.Data = &SIfElse{op, r[2], r[4], res2.Data, pos}
 		res.Next = res2.Next
 	} else {
-		res.Data = &SCond{op, r[2], r[4], tokens.Pos}
+		res.Data = &SCond{op, r[2], r[4], pos}
 	}
 	return res
 }
@@ -188,6 +275,17 @@ var stmt_for1 = parser.ArraySeq{
 	parsex.Snip{parser.Delegate("Stmt")}, // 4
 }
 
+var stmt_for0 = parser.ArraySeq{
+	require(KW_for),
+	require(KW_my), // -2
+	require('$'),//-1
+	parsex.Snip{parser.Pfunc(d_ident)}, // 0
+	parsex.Snip{require('(')},
+	parsex.Snip{parser.Delegate("Expr")}, // 2
+	parsex.Snip{require(')')},
+	parsex.Snip{parser.Delegate("Stmt")}, // 4
+}
+
 var stmt_for2 = parser.ArraySeq{
 	require(KW_for), // 0 (overwritten)
 	parsex.Snip{require('(')},
@@ -196,20 +294,21 @@ var stmt_for2 = parser.ArraySeq{
 	parsex.Snip{parser.Delegate("Stmt")}, // 4
 }
 
-var stmt_for = parser.OR{stmt_for1,stmt_for2}
+var stmt_for = parser.OR{stmt_for0,stmt_for1,stmt_for2}
 
 func d_stmt_for(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
 	res := stmt_for.Parse(p,tokens,nil)
 	if !res.Ok() { return res }
 	r := res.Data.([]interface{})
 	
-	if len(r)==7 {
-		r = r[2:]
-	} else {
-		r[0] = "_"
+	my := len(r)==8
+	switch len(r) {
+	case 8: r = r[3:]
+	case 7: r = r[2:]
+	default: r[0] = "_"
 	}
 	
-	res.Data = &SFor{r[0].(string), r[2], r[4], tokens.Pos}
+	res.Data = &SFor{r[0].(string), r[2], r[4], tokens.Pos, my}
 	
 	return res
 }
@@ -221,6 +320,20 @@ func d_stmt_eval(p *parser.Parser,tokens *scanlist.Element, left interface{}) pa
 	return res
 }
 
+var stmt_label = parser.ArraySeq{require(scanner.Ident),require(':')}
+func d_stmt_labeled(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
+	res := stmt_label.Parse(p,tokens,nil)
+	if !res.Ok() { return res }
+	
+	// Foo::bar() is not a label.
+	if ok,_ := parser.FastMatch(res.Next,':'); ok { return parser.ResultFail("Not a label!",tokens.Pos) }
+	
+	label := res.Data.([]interface{})[0].(string)
+	res = parsex.DoCut(p.Match("Stmt",res.Next))
+	if res.Ok() { res.Data = &SLabeled{label,res.Data,tokens.Pos} }
+	return res
+}
+
 var stmt_semicolon = require(';')
 func d_stmt_semicolon(p *parser.Parser,tokens *scanlist.Element, left interface{}) parser.ParserResult {
 	res := stmt_semicolon.Parse(p,tokens,left)
@@ -231,17 +344,21 @@ func d_stmt_semicolon(p *parser.Parser,tokens *scanlist.Element, left interface{
 
 func RegisterStmt(p *parser.Parser) {
 	p.Define("Decl",false,parser.Pfunc(d_decl_myvar))
+	p.Define("Decl",false,parser.Pfunc(d_decl_ourvar))
 	
 	p.Define("StmtSub",false,parser.Pfunc(d_stmtsub_expr))
 	p.Define("StmtSub",false,stmtsub_do)
 	p.Define("StmtSub",false,parser.Pfunc(d_stmtsub_print))
 	p.Define("StmtSub",false,parser.Pfunc(d_stmtsub_loopjmp))
+	p.Define("StmtSub",false,parser.Pfunc(d_stmtsub_require))
+	p.Define("StmtSub",false,parser.Pfunc(d_stmtsub_return))
 	p.Define("StmtSub",true,parser.Pfunc(d_stmtsub_cond))
 	
 	p.Define("Stmt",false,parser.Pfunc(d_stmt_semicolon))
 	p.Define("Stmt",false,parser.Pfunc(d_stmt_cond))
 	p.Define("Stmt",false,parser.Pfunc(d_stmt_for))
 	p.Define("Stmt",false,parser.Pfunc(d_stmt_eval))
+	p.Define("Stmt",false,parser.Pfunc(d_stmt_labeled))
 	p.Define("Stmt",false,parser.Pfunc(d_stmt_block))
 	p.Define("Stmt",false,parser.Pfunc(d_stmt_sub))
 	
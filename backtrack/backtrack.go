@@ -0,0 +1,142 @@
+/*
+Copyright (c) 2021 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+/*
+A backtracking regex engine.
+
+Unlike RE2 (package regexp), it supports lookahead and lookbehind assertions, backreferences,
+atomic groups and possessive quantifiers. The price is an exponential worst case, so every
+search is limited to a number of steps, after which it panics. It also panics, if the
+matches nest too deeply, as they are recursive.
+
+The syntax is the one of package regexp, with these additions:
+
+	(?=re) (?!re)         lookahead
+	(?<=re) (?<!re)       lookbehind (of any width)
+	(?>re)                atomic group
+	x*+ x++ x?+ x{n,m}+   possessive quantifiers
+	\1 \g1 \g{1} \g{-1}   backreferences
+	\k<name> (?P=name)    named backreferences
+	(?<name>re) (?'name're)  named groups
+	\G                    the position, the search started at
+	\Z                    the end of the text or before a final newline
+*/
+package backtrack
+
+import "unicode/utf8"
+import "fmt"
+
+/* The number of steps a search may take, unless the Regexp has its own StepLimit. */
+var DefaultStepLimit = 10000000
+
+/*
+The number of nested matches (such as the iterations of a group in a repetition) a search
+may have, before it panics, rather than exhausting the stack.
+*/
+var DefaultDepthLimit = 500000
+
+type Regexp struct{
+	src string
+	prog *node
+	ncap int // number of groups
+	names []string
+	anchored bool // the pattern starts with \A or \G
+	
+	StepLimit int // 0: DefaultStepLimit
+}
+
+/* Parses a regex. */
+func Compile(src string) (re *Regexp, err error) {
+	defer func() {
+		if e,ok := recover().(*Error); ok {
+			re,err = nil,e
+		} else if e!=nil {
+			panic(e)
+		}
+	}()
+	p := &parser{src:src,names:[]string{""}}
+	prog := p.parse()
+	re = &Regexp{src:src,prog:prog,ncap:len(p.names)-1,names:p.names}
+	p.resolve(re)
+	re.anchored = anchored(prog)
+	return
+}
+/* Like Compile, but panics, if the regex can't be parsed. */
+func MustCompile(src string) *Regexp {
+	re,err := Compile(src)
+	if err!=nil { panic(err) }
+	return re
+}
+
+func (re *Regexp) String() string { return re.src }
+func (re *Regexp) NumSubexp() int { return re.ncap }
+func (re *Regexp) SubexpNames() []string { return re.names }
+
+/*
+Finds the leftmost match in s at or after start. The result has the same layout as the one
+of regexp.FindStringSubmatchIndex, but the offsets are relative to s, not to s[start:].
+Lookbehind assertions see the text before start, and \G matches at start.
+*/
+func (re *Regexp) FindAt(s string, start int) []int {
+	if start<0 || start>len(s) { return nil }
+	limit := re.StepLimit
+	if limit<=0 { limit = DefaultStepLimit }
+	m := &machine{re:re,in:s,start:start,limit:limit,caps:make([]int,2*re.ncap+2)}
+	for i := start; ; {
+		for j := range m.caps { m.caps[j] = -1 }
+		if m.run(re.prog,i,func(e int) bool { m.caps[0],m.caps[1] = i,e; return true }) {
+			return m.caps
+		}
+		if i>=len(s) || re.anchored { break }
+		_,w := utf8.DecodeRuneInString(s[i:])
+		i += w
+	}
+	return nil
+}
+func (re *Regexp) FindStringSubmatchIndex(s string) []int { return re.FindAt(s,0) }
+func (re *Regexp) MatchString(s string) bool { return re.FindAt(s,0)!=nil }
+
+/* The panic value of a search, that exceeded its step limit. */
+type StepLimitError struct{
+	Pattern string
+	Steps int
+}
+func (e *StepLimitError) Error() string {
+	return fmt.Sprintf("regex step limit (%d) exceeded: /%s/",e.Steps,e.Pattern)
+}
+
+/* The panic value of a search, that nested too deeply. */
+type DepthLimitError struct{
+	Pattern string
+	Depth int
+}
+func (e *DepthLimitError) Error() string {
+	return fmt.Sprintf("regex recursion limit (%d) exceeded: /%s/",e.Depth,e.Pattern)
+}
+
+/* A syntax error. */
+type Error struct{
+	Code string
+	Expr string
+}
+func (e *Error) Error() string { return "error parsing regexp: "+e.Code+": `"+e.Expr+"`" }
@@ -0,0 +1,69 @@
+/*
+Copyright (c) 2021 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package backtrack
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Patterns, that RE2 supports as well, must match the same way.
+func TestLikeRE2(t *testing.T) {
+	cases := []struct{ pat, s string }{
+		{`(a*)*b`, "b"},
+		{`(a*)*b`, "aab"},
+		{`(a*)+b`, "b"},
+		{`(a|b)*`, "ab"},
+		{`a*?b`, "aaab"},
+		{`a{2,3}`, "aaaa"},
+		{`a{2,3}?`, "aaaa"},
+		{`[a-c]+c`, "abcabc"},
+		{`(?i)x+`, "aXxX"},
+		{`(\w+)\s(\w+)`, "hello big world"},
+	}
+	for _,c := range cases {
+		got := fmt.Sprint(MustCompile(c.pat).FindAt(c.s,0))
+		want := fmt.Sprint(regexp.MustCompile(c.pat).FindStringSubmatchIndex(c.s))
+		if got!=want { t.Errorf("/%s/ on %q: got %s, want %s",c.pat,c.s,got,want) }
+	}
+}
+
+func TestLongRepetition(t *testing.T) {
+	s := strings.Repeat("w",1000000)
+	if loc := MustCompile(`(?<=^)\w+`).FindAt(s,0); loc==nil || loc[1]!=len(s) {
+		t.Errorf("got %v",loc)
+	}
+}
+
+func TestDepthLimit(t *testing.T) {
+	defer func(d int) { DefaultDepthLimit = d }(DefaultDepthLimit)
+	DefaultDepthLimit = 1000
+	defer func() {
+		if _,ok := recover().(*DepthLimitError); !ok { t.Error("expected a DepthLimitError") }
+	}()
+	MustCompile(`(?<=^)(?:(\w+)\s?)*`).FindAt(strings.Repeat("word ",1000),0)
+}
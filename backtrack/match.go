@@ -0,0 +1,280 @@
+/*
+Copyright (c) 2021 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package backtrack
+
+import "strings"
+import "unicode"
+import "unicode/utf8"
+
+type class struct{
+	neg bool
+	fold bool // case insensitive
+	ranges []rune // pairs of lo,hi
+	tabs []*unicode.RangeTable // \pL, ...
+	subs []*class // \d, [:alpha:], ... within [...]
+}
+
+func (c *class) contains(r rune) bool {
+	for i := 0; i<len(c.ranges); i += 2 {
+		if c.ranges[i]<=r && r<=c.ranges[i+1] { return true }
+	}
+	for _,tab := range c.tabs {
+		if unicode.Is(tab,r) { return true }
+	}
+	for _,sub := range c.subs {
+		if sub.matches(r) { return true }
+	}
+	return false
+}
+func (c *class) matches(r rune) bool {
+	ok := c.contains(r)
+	if !ok && c.fold {
+		for f := unicode.SimpleFold(r); f!=r; f = unicode.SimpleFold(f) {
+			if c.contains(f) { ok = true; break }
+		}
+	}
+	return ok!=c.neg
+}
+
+func equalFold(a, b rune) bool {
+	if a==b { return true }
+	for f := unicode.SimpleFold(a); f!=a; f = unicode.SimpleFold(f) {
+		if f==b { return true }
+	}
+	return false
+}
+
+func isWordByte(c byte) bool {
+	return c=='_' || c>='0'&&c<='9' || c>='a'&&c<='z' || c>='A'&&c<='Z'
+}
+
+type machine struct{
+	re *Regexp
+	in string
+	start int // \G
+	caps []int
+	steps int
+	limit int
+	depth int // the number of nested calls of run
+}
+
+func (m *machine) step() {
+	m.steps++
+	if m.steps>m.limit { panic(&StepLimitError{m.re.src,m.limit}) }
+}
+func (m *machine) save() []int { return append([]int(nil),m.caps...) }
+func (m *machine) restore(caps []int) { copy(m.caps,caps) }
+
+/*
+Matches n at position i. On success, the continuation k is called with the end of the match.
+If k fails, other ways to match n are tried. The result is the one of the last call to k.
+The continuations nest on the Go stack, so the depth is limited (see DefaultDepthLimit).
+*/
+func (m *machine) run(n *node, i int, k func(int) bool) bool {
+	m.step()
+	m.depth++
+	if m.depth>DefaultDepthLimit { panic(&DepthLimitError{m.re.src,DefaultDepthLimit}) }
+	ok := m.match(n,i,k)
+	m.depth--
+	return ok
+}
+func (m *machine) match(n *node, i int, k func(int) bool) bool {
+	switch n.op {
+	case opEmpty:
+		return k(i)
+	case opLit,opAny,opClass:
+		w := m.single(n,i)
+		if w==0 { return false }
+		return k(i+w)
+	case opSeq:
+		return m.seq(n.subs,i,k)
+	case opAlt:
+		for _,sub := range n.subs {
+			if m.run(sub,i,k) { return true }
+		}
+		return false
+	case opCap:
+		g := 2*n.n
+		return m.run(n.subs[0],i,func(j int) bool {
+			s,e := m.caps[g],m.caps[g+1]
+			m.caps[g],m.caps[g+1] = i,j
+			if k(j) { return true }
+			m.caps[g],m.caps[g+1] = s,e
+			return false
+		})
+	case opRepeat:
+		if n.possessive { return m.once(n,i,k,func(k2 func(int) bool) bool { return m.repeat(n,0,i,k2) }) }
+		return m.repeat(n,0,i,k)
+	case opAtomic:
+		return m.once(n,i,k,func(k2 func(int) bool) bool { return m.run(n.subs[0],i,k2) })
+	case opLook:
+		return m.look(n,i,k)
+	case opRef:
+		s,e := m.caps[2*n.n],m.caps[2*n.n+1]
+		if s<0 { return false }
+		j,ok := m.prefix(m.in[s:e],i,n.fold)
+		if !ok { return false }
+		return k(j)
+	case opAssert:
+		if !m.assert(n,i) { return false }
+		return k(i)
+	}
+	panic("backtrack: invalid opcode")
+}
+
+// Matches a node of a single rune (opLit, opAny or opClass) at i. Returns its width, or 0.
+func (m *machine) single(n *node, i int) int {
+	r,w := utf8.DecodeRuneInString(m.in[i:])
+	if w==0 { return 0 }
+	switch n.op {
+	case opLit: if r!=n.r && !(n.fold && equalFold(r,n.r)) { return 0 }
+	case opAny: if r=='\n' && !n.dotall { return 0 }
+	case opClass: if !n.cls.matches(r) { return 0 }
+	}
+	return w
+}
+
+func (m *machine) seq(subs []*node, i int, k func(int) bool) bool {
+	if len(subs)==0 { return k(i) }
+	return m.run(subs[0],i,func(j int) bool { return m.seq(subs[1:],j,k) })
+}
+
+func (m *machine) repeat(n *node, count, i int, k func(int) bool) bool {
+	switch n.subs[0].op {
+	case opLit,opAny,opClass: return m.repeatSingle(n,i,k)
+	}
+	m.step()
+	more := func() bool {
+		return m.run(n.subs[0],i,func(j int) bool {
+			/*
+			Beyond the minimum, an empty iteration would loop forever. As in RE2, it is
+			accepted as the first iteration only, which ends the repetition.
+			*/
+			if j==i && count>=n.min {
+				if count>0 { return false }
+				return k(j)
+			}
+			return m.repeat(n,count+1,j,k)
+		})
+	}
+	if count<n.min { return more() }
+	canMore := n.max<0 || count<n.max
+	if n.lazy {
+		if k(i) { return true }
+		return canMore && more()
+	}
+	if canMore && more() { return true }
+	return k(i)
+}
+
+/*
+Repeats a node of a single rune without recursion: the greedy form collects the ends of
+all iterations first and then tries the longest one first.
+*/
+func (m *machine) repeatSingle(n *node, i int, k func(int) bool) bool {
+	sub := n.subs[0]
+	if n.lazy {
+		for count := 0; ; count++ {
+			m.step()
+			if count>=n.min && k(i) { return true }
+			if n.max>=0 && count>=n.max { return false }
+			w := m.single(sub,i)
+			if w==0 { return false }
+			i += w
+		}
+	}
+	ends := []int{i}
+	for n.max<0 || len(ends)<=n.max {
+		m.step()
+		w := m.single(sub,i)
+		if w==0 { break }
+		i += w
+		ends = append(ends,i)
+	}
+	for c := len(ends)-1; c>=n.min; c-- {
+		if k(ends[c]) { return true }
+	}
+	return false
+}
+
+// Atomic groups and possessive quantifiers: only the first way to match is tried.
+func (m *machine) once(n *node, i int, k func(int) bool, first func(func(int) bool) bool) bool {
+	saved := m.save()
+	end := -1
+	if !first(func(j int) bool { end = j; return true }) { return false }
+	if k(end) { return true }
+	m.restore(saved)
+	return false
+}
+
+func (m *machine) look(n *node, i int, k func(int) bool) bool {
+	saved := m.save()
+	ok := false
+	if n.behind {
+		for j := i; j>=0 && !ok; j-- {
+			if j<len(m.in) && !utf8.RuneStart(m.in[j]) { continue }
+			ok = m.run(n.subs[0],j,func(e int) bool { return e==i })
+		}
+	} else {
+		ok = m.run(n.subs[0],i,func(int) bool { return true })
+	}
+	if ok==n.neg {
+		m.restore(saved)
+		return false
+	}
+	if k(i) { return true }
+	m.restore(saved)
+	return false
+}
+
+// Matches the text of a backreference at i.
+func (m *machine) prefix(s string, i int, fold bool) (int,bool) {
+	if !fold {
+		if !strings.HasPrefix(m.in[i:],s) { return 0,false }
+		return i+len(s),true
+	}
+	for _,r := range s {
+		c,w := utf8.DecodeRuneInString(m.in[i:])
+		if w==0 || !equalFold(r,c) { return 0,false }
+		i += w
+	}
+	return i,true
+}
+
+func (m *machine) assert(n *node, i int) bool {
+	in := m.in
+	switch n.n {
+	case aBOL: return i==0 || (n.multi && in[i-1]=='\n')
+	case aEOL: return i==len(in) || (n.multi && in[i]=='\n')
+	case aBOT: return i==0
+	case aEOT: return i==len(in)
+	case aEOTNL: return i==len(in) || (i==len(in)-1 && in[i]=='\n')
+	case aStart: return i==m.start
+	case aWord, aNotWord:
+		a := i>0 && isWordByte(in[i-1])
+		b := i<len(in) && isWordByte(in[i])
+		return (a!=b)==(n.n==aWord)
+	}
+	return false
+}
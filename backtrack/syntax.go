@@ -0,0 +1,528 @@
+/*
+Copyright (c) 2021 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package backtrack
+
+import "strconv"
+import "strings"
+import "unicode"
+import "unicode/utf8"
+
+type opcode uint8
+const (
+	opEmpty opcode = iota
+	opLit // the rune r
+	opAny // any rune, but \n, unless dotall
+	opClass
+	opSeq
+	opAlt
+	opCap // group n
+	opRepeat
+	opLook // lookahead or lookbehind
+	opAtomic
+	opRef // backreference to group n
+	opAssert // zero-width assertion n
+)
+
+// Assertions
+const (
+	aBOL = iota // ^
+	aEOL // $
+	aBOT // \A
+	aEOT // \z
+	aEOTNL // \Z
+	aStart // \G
+	aWord // \b
+	aNotWord // \B
+)
+
+type node struct{
+	op opcode
+	r rune
+	fold bool // case insensitive
+	dotall bool // . matches \n
+	multi bool // ^ and $ match at lines
+	cls *class
+	subs []*node
+	n int // group or assertion
+	min,max int // repetition, max<0 is unbounded
+	lazy,possessive bool
+	neg,behind bool // negative, lookbehind
+	name string // named backreference, resolved after parsing
+}
+
+type flags struct{
+	i,m,s,U bool
+}
+
+type parser struct{
+	src string
+	pos int
+	names []string // names of the groups, names[0] is the whole match
+	refs []*node // backreferences to check
+}
+
+func (p *parser) fail(code string, expr string) {
+	panic(&Error{code,expr})
+}
+func (p *parser) more() bool { return p.pos<len(p.src) }
+func (p *parser) peek() rune {
+	r,_ := utf8.DecodeRuneInString(p.src[p.pos:])
+	return r
+}
+func (p *parser) next() rune {
+	r,w := utf8.DecodeRuneInString(p.src[p.pos:])
+	p.pos += w
+	return r
+}
+func (p *parser) lookingAt(s string) bool { return strings.HasPrefix(p.src[p.pos:],s) }
+
+func (p *parser) parse() *node {
+	fl := flags{}
+	n := p.alt(&fl)
+	if p.more() { p.fail("unexpected )",p.src) }
+	return n
+}
+
+// Checks the backreferences, once all groups are known.
+func (p *parser) resolve(re *Regexp) {
+	for _,ref := range p.refs {
+		if ref.name!="" {
+			ref.n = -1
+			for i,name := range p.names {
+				if name==ref.name { ref.n = i; break }
+			}
+			if ref.n<0 { p.fail("invalid named reference",ref.name) }
+		}
+		if ref.n<1 || ref.n>re.ncap { p.fail("invalid backreference",`\`+strconv.Itoa(ref.n)) }
+	}
+}
+
+// alternation: seq | seq | ...
+func (p *parser) alt(fl *flags) *node {
+	var subs []*node
+	for {
+		subs = append(subs,p.seq(fl))
+		if !p.more() || p.peek()!='|' { break }
+		p.pos++
+	}
+	if len(subs)==1 { return subs[0] }
+	return &node{op:opAlt,subs:subs}
+}
+
+func (p *parser) seq(fl *flags) *node {
+	var subs []*node
+	for p.more() {
+		c := p.peek()
+		if c=='|' || c==')' { break }
+		start := p.pos
+		atom := p.atom(fl)
+		if atom==nil { continue } // (?flags)
+		atom = p.quantifier(fl,atom,start)
+		subs = append(subs,atom)
+	}
+	switch len(subs) {
+	case 0: return &node{op:opEmpty}
+	case 1: return subs[0]
+	}
+	return &node{op:opSeq,subs:subs}
+}
+
+func (p *parser) quantifier(fl *flags, atom *node, start int) *node {
+	if !p.more() { return atom }
+	min,max := 0,-1
+	switch p.peek() {
+	case '*': p.pos++
+	case '+': p.pos++; min = 1
+	case '?': p.pos++; max = 1
+	case '{':
+		var ok bool
+		if min,max,ok = p.repeat(); !ok { return atom }
+	default:
+		return atom
+	}
+	rep := &node{op:opRepeat,subs:[]*node{atom},min:min,max:max,lazy:fl.U}
+	if p.more() {
+		switch p.peek() {
+		case '?': p.pos++; rep.lazy = !fl.U
+		case '+': p.pos++; rep.possessive,rep.lazy = true,false
+		}
+	}
+	if p.more() && strings.ContainsRune("*+?{",p.peek()) {
+		if _,_,ok := p.peekRepeat(); ok || p.peek()!='{' {
+			p.fail("invalid nested repetition operator",p.src[start:p.pos+1])
+		}
+	}
+	return rep
+}
+
+func (p *parser) peekRepeat() (min, max int, ok bool) {
+	pos := p.pos
+	min,max,ok = p.repeat()
+	p.pos = pos
+	return
+}
+
+// Parses {n}, {n,} or {n,m}. A brace, that doesn't start a repetition, is a literal.
+func (p *parser) repeat() (min, max int, ok bool) {
+	s := p.src[p.pos:]
+	end := strings.IndexByte(s,'}')
+	if end<0 { return }
+	body := s[1:end]
+	lo,hi := body,body
+	if i := strings.IndexByte(body,','); i>=0 { lo,hi = body[:i],body[i+1:] }
+	var err error
+	if min,err = strconv.Atoi(lo); err!=nil || min<0 { return }
+	switch {
+	case hi=="": max = -1
+	default:
+		if max,err = strconv.Atoi(hi); err!=nil { return }
+		if max<min { p.fail("invalid repeat count",s[:end+1]) }
+	}
+	if min>1000 || max>1000 { p.fail("invalid repeat count",s[:end+1]) }
+	p.pos += end+1
+	return min,max,true
+}
+
+func (p *parser) atom(fl *flags) *node {
+	c := p.next()
+	switch c {
+	case '(':
+		return p.group(fl)
+	case '[':
+		return &node{op:opClass,cls:p.class(fl)}
+	case '.':
+		return &node{op:opAny,dotall:fl.s}
+	case '^':
+		return &node{op:opAssert,n:aBOL,multi:fl.m}
+	case '$':
+		return &node{op:opAssert,n:aEOL,multi:fl.m}
+	case '\\':
+		return p.escape(fl)
+	case '*','+','?':
+		p.fail("missing argument to repetition operator",string(c))
+	}
+	return &node{op:opLit,r:c,fold:fl.i}
+}
+
+func (p *parser) name(end byte) string {
+	i := strings.IndexByte(p.src[p.pos:],end)
+	if i<=0 { p.fail("invalid named capture",p.src[p.pos:]) }
+	name := p.src[p.pos:p.pos+i]
+	for _,r := range name {
+		if r!='_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) { p.fail("invalid named capture",name) }
+	}
+	p.pos += i+1
+	return name
+}
+
+func (p *parser) capture(fl *flags, name string) *node {
+	n := len(p.names)
+	p.names = append(p.names,name)
+	inner := *fl
+	sub := p.alt(&inner)
+	p.close()
+	return &node{op:opCap,n:n,subs:[]*node{sub}}
+}
+func (p *parser) sub(fl *flags) *node {
+	inner := *fl
+	sub := p.alt(&inner)
+	p.close()
+	return sub
+}
+func (p *parser) close() {
+	if !p.more() || p.next()!=')' { p.fail("missing closing )",p.src) }
+}
+
+func (p *parser) group(fl *flags) *node {
+	if !p.lookingAt("?") { return p.capture(fl,"") }
+	p.pos++
+	switch {
+	case p.lookingAt("P<"):
+		p.pos += 2
+		return p.capture(fl,p.name('>'))
+	case p.lookingAt("P="):
+		p.pos += 2
+		ref := &node{op:opRef,name:p.name(')'),fold:fl.i}
+		p.refs = append(p.refs,ref)
+		return ref
+	case p.lookingAt("<="), p.lookingAt("<!"):
+		neg := p.src[p.pos+1]=='!'
+		p.pos += 2
+		return &node{op:opLook,neg:neg,behind:true,subs:[]*node{p.sub(fl)}}
+	case p.lookingAt("<"):
+		p.pos++
+		return p.capture(fl,p.name('>'))
+	case p.lookingAt("'"):
+		p.pos++
+		return p.capture(fl,p.name('\''))
+	case p.lookingAt("="), p.lookingAt("!"):
+		neg := p.src[p.pos]=='!'
+		p.pos++
+		return &node{op:opLook,neg:neg,subs:[]*node{p.sub(fl)}}
+	case p.lookingAt(">"):
+		p.pos++
+		return &node{op:opAtomic,subs:[]*node{p.sub(fl)}}
+	case p.lookingAt(":"):
+		p.pos++
+		return p.sub(fl)
+	case p.lookingAt("#"):
+		i := strings.IndexByte(p.src[p.pos:],')')
+		if i<0 { p.fail("missing closing )",p.src) }
+		p.pos += i+1
+		return nil
+	}
+	
+	// (?flags) or (?flags:re)
+	start := p.pos
+	nf := *fl
+	on := true
+	for p.more() {
+		c := p.next()
+		switch c {
+		case 'i': nf.i = on
+		case 'm': nf.m = on
+		case 's': nf.s = on
+		case 'U': nf.U = on
+		case '-':
+			if !on { p.fail("missing argument to flags",p.src[start-2:p.pos]) }
+			on = false
+		case ')':
+			*fl = nf
+			return nil
+		case ':':
+			return p.sub(&nf)
+		default:
+			p.fail("invalid or unsupported Perl syntax",p.src[start-2:p.pos])
+		}
+	}
+	p.fail("missing closing )",p.src)
+	return nil
+}
+
+func (p *parser) escape(fl *flags) *node {
+	if !p.more() { p.fail("trailing backslash at end of expression","") }
+	start := p.pos-1
+	c := p.next()
+	switch c {
+	case 'A': return &node{op:opAssert,n:aBOT}
+	case 'z': return &node{op:opAssert,n:aEOT}
+	case 'Z': return &node{op:opAssert,n:aEOTNL}
+	case 'G': return &node{op:opAssert,n:aStart}
+	case 'b': return &node{op:opAssert,n:aWord}
+	case 'B': return &node{op:opAssert,n:aNotWord}
+	case 'Q':
+		end := strings.Index(p.src[p.pos:],`\E`)
+		lit := p.src[p.pos:]
+		if end>=0 { lit = lit[:end]; p.pos += end+2 } else { p.pos = len(p.src) }
+		seq := &node{op:opSeq}
+		for _,r := range lit { seq.subs = append(seq.subs,&node{op:opLit,r:r,fold:fl.i}) }
+		return seq
+	case 'k':
+		if !p.more() { break }
+		var end byte
+		switch p.next() {
+		case '<': end = '>'
+		case '{': end = '}'
+		case '\'': end = '\''
+		default: p.fail("invalid escape sequence",p.src[start:p.pos])
+		}
+		ref := &node{op:opRef,name:p.name(end),fold:fl.i}
+		p.refs = append(p.refs,ref)
+		return ref
+	case 'g':
+		braced := p.more() && p.peek()=='{'
+		if braced { p.pos++ }
+		i := p.pos
+		if p.more() && p.peek()=='-' { p.pos++ }
+		for p.more() && p.peek()>='0' && p.peek()<='9' { p.pos++ }
+		n,err := strconv.Atoi(p.src[i:p.pos])
+		if err!=nil { p.fail("invalid escape sequence",p.src[start:p.pos]) }
+		if braced && (!p.more() || p.next()!='}') { p.fail("invalid escape sequence",p.src[start:p.pos]) }
+		if n<0 { n += len(p.names) } // relative to the groups opened so far
+		ref := &node{op:opRef,n:n,fold:fl.i}
+		p.refs = append(p.refs,ref)
+		return ref
+	case '1','2','3','4','5','6','7','8','9':
+		for p.more() && p.peek()>='0' && p.peek()<='9' { p.pos++ }
+		n,_ := strconv.Atoi(p.src[start+1:p.pos])
+		ref := &node{op:opRef,n:n,fold:fl.i}
+		p.refs = append(p.refs,ref)
+		return ref
+	}
+	p.pos = start+1
+	if cls := p.classEscape(); cls!=nil { return &node{op:opClass,cls:cls} }
+	return &node{op:opLit,r:p.charEscape(start),fold:fl.i}
+}
+// Parses an escape sequence, that stands for a single character (after the backslash).
+func (p *parser) charEscape(start int) rune {
+	c := p.next()
+	switch c {
+	case 'n': return '\n'
+	case 't': return '\t'
+	case 'r': return '\r'
+	case 'f': return '\f'
+	case 'v': return '\v'
+	case 'a': return '\a'
+	case 'e': return 0x1b
+	case '0':
+		i := p.pos
+		for p.pos<i+2 && p.more() && p.peek()>='0' && p.peek()<='7' { p.pos++ }
+		v,_ := strconv.ParseUint("0"+p.src[i:p.pos],8,32)
+		return rune(v)
+	case 'x':
+		var hex string
+		if p.lookingAt("{") {
+			end := strings.IndexByte(p.src[p.pos:],'}')
+			if end<0 { p.fail("invalid escape sequence",p.src[start:]) }
+			hex = p.src[p.pos+1:p.pos+end]
+			p.pos += end+1
+		} else if p.pos+2<=len(p.src) {
+			hex = p.src[p.pos:p.pos+2]
+			p.pos += 2
+		}
+		v,err := strconv.ParseUint(hex,16,32)
+		if err!=nil || v>unicode.MaxRune { p.fail("invalid escape sequence",p.src[start:p.pos]) }
+		return rune(v)
+	}
+	if c<utf8.RuneSelf && !unicode.IsLetter(c) && !unicode.IsDigit(c) { return c } // \. \* \\ ...
+	p.fail("invalid escape sequence",p.src[start:p.pos])
+	return 0
+}
+
+// Parses \d, \w, \s, \pL and their negations (after the backslash). Returns nil, if it's none of them.
+func (p *parser) classEscape() *class {
+	start := p.pos-1
+	c := p.peek()
+	var cls *class
+	switch c {
+	case 'd','D': cls = &class{ranges:[]rune{'0','9'}}
+	case 'w','W': cls = &class{ranges:[]rune{'0','9','A','Z','_','_','a','z'}}
+	case 's','S': cls = &class{ranges:[]rune{'\t','\n','\f','\r',' ',' '}}
+	case 'p','P':
+		p.pos++
+		var name string
+		if p.lookingAt("{") {
+			end := strings.IndexByte(p.src[p.pos:],'}')
+			if end<0 { p.fail("invalid character class range",p.src[start:]) }
+			name = p.src[p.pos+1:p.pos+end]
+			p.pos += end+1
+		} else if p.more() {
+			name = string(p.next())
+		}
+		neg := c=='P'
+		if strings.HasPrefix(name,"^") { name,neg = name[1:],!neg }
+		tab := unicode.Categories[name]
+		if tab==nil { tab = unicode.Scripts[name] }
+		if name=="Any" { tab = &unicode.RangeTable{R32:[]unicode.Range32{{0,unicode.MaxRune,1}}} }
+		if tab==nil { p.fail("invalid character class range",p.src[start:p.pos]) }
+		return &class{tabs:[]*unicode.RangeTable{tab},neg:neg}
+	default:
+		return nil
+	}
+	p.pos++
+	cls.neg = c>='A' && c<='Z'
+	return cls
+}
+
+var posixClasses = map[string][]rune{
+	"alnum": {'0','9','A','Z','a','z'},
+	"alpha": {'A','Z','a','z'},
+	"ascii": {0,0x7f},
+	"blank": {'\t','\t',' ',' '},
+	"cntrl": {0,0x1f,0x7f,0x7f},
+	"digit": {'0','9'},
+	"graph": {'!','~'},
+	"lower": {'a','z'},
+	"print": {' ','~'},
+	"punct": {'!','/',':','@','[','`','{','~'},
+	"space": {'\t','\r',' ',' '},
+	"upper": {'A','Z'},
+	"word": {'0','9','A','Z','_','_','a','z'},
+	"xdigit": {'0','9','A','F','a','f'},
+}
+
+// Parses a character class after the [.
+func (p *parser) class(fl *flags) *class {
+	start := p.pos-1
+	cls := &class{fold:fl.i}
+	if p.lookingAt("^") { p.pos++; cls.neg = true }
+	first := true
+	for {
+		if !p.more() { p.fail("missing closing ]",p.src[start:]) }
+		if p.peek()==']' && !first { p.pos++; break }
+		first = false
+		if p.lookingAt("[:") {
+			end := strings.Index(p.src[p.pos:],":]")
+			if end>0 {
+				name := p.src[p.pos+2:p.pos+end]
+				neg := strings.HasPrefix(name,"^")
+				if neg { name = name[1:] }
+				r,ok := posixClasses[name]
+				if !ok { p.fail("invalid character class range",p.src[p.pos:p.pos+end+2]) }
+				p.pos += end+2
+				cls.subs = append(cls.subs,&class{ranges:r,neg:neg})
+				continue
+			}
+		}
+		lo := p.classChar(cls)
+		if lo<0 { continue }
+		hi := lo
+		if p.lookingAt("-") && !p.lookingAt("-]") {
+			p.pos++
+			i := p.pos
+			if hi = p.classChar(cls); hi<0 || hi<lo { p.fail("invalid character class range",p.src[i-2:p.pos]) }
+		}
+		cls.ranges = append(cls.ranges,lo,hi)
+	}
+	return cls
+}
+// Parses a character of a class, or an escaped class, which is added to cls (the result is -1 then).
+func (p *parser) classChar(cls *class) rune {
+	c := p.next()
+	if c!='\\' { return c }
+	if !p.more() { p.fail("trailing backslash at end of expression","") }
+	if sub := p.classEscape(); sub!=nil {
+		cls.subs = append(cls.subs,sub)
+		return -1
+	}
+	if p.peek()=='b' { p.pos++; return '\b' }
+	return p.charEscape(p.pos-1)
+}
+
+// Whether the regex can only match at the start position.
+func anchored(n *node) bool {
+	for {
+		switch n.op {
+		case opSeq:
+			if len(n.subs)==0 { return false }
+			n = n.subs[0]
+		case opCap, opAtomic:
+			n = n.subs[0]
+		case opAssert:
+			return n.n==aBOT || n.n==aStart
+		default:
+			return false
+		}
+	}
+}
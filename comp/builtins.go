@@ -0,0 +1,133 @@
+/*
+Copyright (c) 2021 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package comp
+
+import "github.com/byte-mug/dream/values"
+import "github.com/byte-mug/dream/vm"
+
+/*
+Functions, that are part of the language. They are called like subs and get their arguments
+in @_ (ts.Args), where they leave their results, but they take precedence over subs of the
+same name. ctx is the context of the call (vm.CTX_*).
+*/
+type builtin func(ts *vm.ThreadState, ctx int)
+
+var builtins = map[string]builtin{
+	"pack": bi_pack,
+	"unpack": bi_unpack,
+	"length": bi_length,
+	"substr": bi_substr,
+	"ord": bi_ord,
+	"chr": bi_chr,
+	"encode": bi_encode,
+	"decode": bi_decode,
+	"looks_like_number": bi_looks_like_number,
+	"int": bi_int,
+	"join": bi_join,
+}
+
+func builtincall(f builtin, ctx int) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		f(ts,ctx)
+	}
+}
+
+// Returns the i-th argument, or undef.
+func biArg(ts *vm.ThreadState, i int) values.Scalar {
+	if i<len(ts.Args) { return ts.Args[i] }
+	return values.Null()
+}
+// Sets the result. In scalar context, a list yields its first element.
+func biReturn(ts *vm.ThreadState, ctx int, res ...values.Scalar) {
+	if ctx==vm.CTX_Scalar && len(res)>1 { res = res[:1] }
+	ts.Args = append(ts.Args[:0],res...)
+}
+
+// pack TEMPLATE, LIST
+func bi_pack(ts *vm.ThreadState, ctx int) {
+	if len(ts.Args)==0 { panic("Not enough arguments for pack") }
+	buf := values.Pack(ts.Args[0].String(),ts.Args[1:])
+	biReturn(ts,ctx,buf)
+}
+
+// unpack TEMPLATE, EXPR
+func bi_unpack(ts *vm.ThreadState, ctx int) {
+	if len(ts.Args)==0 { panic("Not enough arguments for unpack") }
+	res := values.Unpack(ts.Args[0].String(),biArg(ts,1).Bytes())
+	biReturn(ts,ctx,res...)
+}
+
+// length EXPR
+func bi_length(ts *vm.ThreadState, ctx int) {
+	biReturn(ts,ctx,values.Length(biArg(ts,0)))
+}
+
+// substr EXPR, OFFSET[, LENGTH]
+func bi_substr(ts *vm.ThreadState, ctx int) {
+	if len(ts.Args)<2 { panic("Not enough arguments for substr") }
+	biReturn(ts,ctx,values.Substr(ts.Args[0],ts.Args[1],biArg(ts,2)))
+}
+
+// ord EXPR
+func bi_ord(ts *vm.ThreadState, ctx int) {
+	biReturn(ts,ctx,values.Ord(biArg(ts,0)))
+}
+
+// chr NUMBER
+func bi_chr(ts *vm.ThreadState, ctx int) {
+	biReturn(ts,ctx,values.Chr(biArg(ts,0)))
+}
+
+// encode ENCODING, STRING[, CHECK]
+func bi_encode(ts *vm.ThreadState, ctx int) {
+	if len(ts.Args)<2 { panic("Not enough arguments for encode") }
+	biReturn(ts,ctx,values.Encode(ts.Args[0].String(),ts.Args[1].String(),int(biArg(ts,2).Integer())))
+}
+
+// decode ENCODING, OCTETS[, CHECK]
+func bi_decode(ts *vm.ThreadState, ctx int) {
+	if len(ts.Args)<2 { panic("Not enough arguments for decode") }
+	biReturn(ts,ctx,values.Decode(ts.Args[0].String(),ts.Args[1].Bytes(),int(biArg(ts,2).Integer())))
+}
+
+// looks_like_number EXPR
+func bi_looks_like_number(ts *vm.ThreadState, ctx int) {
+	biReturn(ts,ctx,values.Bool2S(values.LooksLikeNumber(biArg(ts,0))))
+}
+
+// int EXPR
+func bi_int(ts *vm.ThreadState, ctx int) {
+	biReturn(ts,ctx,values.Int(biArg(ts,0)))
+}
+
+// join EXPR, LIST
+func bi_join(ts *vm.ThreadState, ctx int) {
+	if len(ts.Args)==0 { panic("Not enough arguments for join") }
+	var res values.Scalar = values.ScString("")
+	for i,v := range ts.Args[1:] {
+		if i>0 { res = values.Concat(res,ts.Args[0]) }
+		res = values.Concat(res,v)
+	}
+	biReturn(ts,ctx,res)
+}
@@ -26,7 +26,10 @@ package comp
 
 import "github.com/byte-mug/dream/values"
 import "github.com/byte-mug/dream/vm"
-import "regexp"
+import "unicode/utf8"
+import "strings"
+import "sync/atomic"
+import "text/scanner"
 import "fmt"
 
 //type vm.InsOp func(ts *vm.ThreadState, ip *int, ln int)
@@ -53,43 +56,103 @@ func require_module(name string) vm.InsOp {
 }
 func require_module_register(reg int) vm.InsOp {
 	return func(ts *vm.ThreadState, ip *int, ln int) {
-		v := ts.RS.SRegs[reg].(*values.ScModule)
+		sc := ts.RS.SRegs[reg]
+		v,_ := sc.(*values.ScModule)
+		if v==nil { v = ts.RS.Proc.GetCl().GetModule(sc.String()).(*values.ScModule) }
 		if _,ok := vm.LoadModule(v,ts); !ok { panic("module not found: "+v.Name) }
 	}
 }
 
 type slotLoader func(ts *vm.ThreadState) values.ScalarSlot
 
+/*
+Refers to a global variable or sub. If the name is qualified ($Foo::Bar::x), the module
+is resolved through the class loader (and loaded, if neccessary) on first use.
+*/
+type globalName struct{
+	Module string // "" for the current module
+	Name string
+	cache atomic.Value // *vm.Module
+}
+func newGlobalName(n string) *globalName {
+	gn := new(globalName)
+	if i := strings.LastIndex(n,"::"); i>=0 {
+		gn.Module,gn.Name = n[:i],n[i+2:]
+	} else {
+		gn.Name = n
+	}
+	return gn
+}
+func (gn *globalName) module(ts *vm.ThreadState) *vm.Module {
+	if gn.Module=="" { return ts.RS.Proc.Parent }
+	if md,ok := gn.cache.Load().(*vm.Module); ok { return md }
+	v := ts.RS.Proc.GetCl().GetModule(gn.Module).(*values.ScModule)
+	md,ok := vm.LoadModule(v,ts)
+	if !ok { panic("module not found: "+v.Name) }
+	gn.cache.Store(md)
+	return md
+}
+func (gn *globalName) scalar(ts *vm.ThreadState, create bool) *values.Scalar {
+	md := gn.module(ts)
+	v,ok := md.Scalars.Load(gn.Name)
+	if !ok {
+		if !create { return nil }
+		sp := new(values.Scalar)
+		*sp = values.Null()
+		v,_ = md.Scalars.LoadOrStore(gn.Name,sp)
+	}
+	return v.(*values.Scalar)
+}
+func (gn *globalName) array(ts *vm.ThreadState, create bool) *values.AV {
+	md := gn.module(ts)
+	v,ok := md.Arrays.Load(gn.Name)
+	if !ok {
+		if !create { return nil }
+		v,_ = md.Arrays.LoadOrStore(gn.Name,new(values.AV))
+	}
+	return v.(*values.AV)
+}
+func (gn *globalName) hash(ts *vm.ThreadState, create bool) *values.HV {
+	md := gn.module(ts)
+	v,ok := md.Hashes.Load(gn.Name)
+	if !ok {
+		if !create { return nil }
+		v,_ = md.Hashes.LoadOrStore(gn.Name,new(values.HV))
+	}
+	return v.(*values.HV)
+}
+
+// our $x, @y, %z
+func declare_global(n string) vm.InsOp {
+	gn := newGlobalName(n[1:])
+	switch n[0] {
+	case '@': return func(ts *vm.ThreadState, ip *int, ln int) { gn.array(ts,true) }
+	case '%': return func(ts *vm.ThreadState, ip *int, ln int) { gn.hash(ts,true) }
+	}
+	return func(ts *vm.ThreadState, ip *int, ln int) { gn.scalar(ts,true) }
+}
+
 func load_global(n string, reg int) vm.InsOp {
+	gn := newGlobalName(n)
 	return func(ts *vm.ThreadState, ip *int, ln int) {
 		rs := ts.RS
-		v,ok := rs.Proc.Parent.Scalars.Load(n)
-		if ok {
-			rs.SRegs[reg] = *(v.(*values.Scalar))
+		if v := gn.scalar(ts,false); v!=nil {
+			rs.SRegs[reg] = *v
 		} else {
 			rs.SRegs[reg] = values.Null()
 		}
 	}
 }
 func store_global(n string, reg int) vm.InsOp {
+	gn := newGlobalName(n)
 	return func(ts *vm.ThreadState, ip *int, ln int) {
-		rs := ts.RS
-		v,ok := rs.Proc.Parent.Scalars.Load(n)
-		if !ok {
-			v,_ = rs.Proc.Parent.Scalars.LoadOrStore(n,new(values.Scalar))
-		}
-		*(v.(*values.Scalar)) = rs.SRegs[reg]
+		*gn.scalar(ts,true) = ts.RS.SRegs[reg]
 	}
 }
 func slot_global(n string) slotLoader {
+	gn := newGlobalName(n)
 	return func(ts *vm.ThreadState) values.ScalarSlot {
-		rs := ts.RS
-		v,ok := rs.Proc.Parent.Scalars.Load(n)
-		if ok {
-			return values.MakeScalarSlot(v.(*values.Scalar))
-		} else {
-			return values.NonSlot()
-		}
+		return values.MakeScalarSlot(gn.scalar(ts,true))
 	}
 }
 func slot_local(reg int) slotLoader {
@@ -99,38 +162,41 @@ func slot_local(reg int) slotLoader {
 }
 
 func load_array_global(n string, reg int) vm.InsOp {
+	gn := newGlobalName(n)
 	return func(ts *vm.ThreadState, ip *int, ln int) {
 		rs := ts.RS
-		v,ok := rs.Proc.Parent.Arrays.Load(n)
-		if ok {
-			rs.ARegs[reg] = append(rs.ARegs[reg],*(v.(*values.AV))...)
+		if av := gn.array(ts,false); av!=nil {
+			rs.ARegs[reg] = append(rs.ARegs[reg][:0],(*av)...)
 		} else {
 			rs.ARegs[reg] = rs.ARegs[reg][:0]
 		}
 	}
 }
 func store_array_global(n string, reg int) vm.InsOp {
+	gn := newGlobalName(n)
 	return func(ts *vm.ThreadState, ip *int, ln int) {
-		rs := ts.RS
-		v,ok := rs.Proc.Parent.Arrays.Load(n)
-		if ok {
-			av := v.(*values.AV)
-			*av = append((*av)[:0],rs.ARegs[reg]...)
-		}
+		av := gn.array(ts,true)
+		*av = append((*av)[:0],ts.RS.ARegs[reg]...)
 	}
 }
 func load_array_unref(r1,rT int) vm.InsOp {
 	return func(ts *vm.ThreadState, ip *int, ln int) {
 		sr := ts.RS.SRegs
 		ar := ts.RS.ARegs
-		ar[rT] = append(ar[rT][:0],*(sr[r1].(*values.ScReference).Data.(*values.AV))...)
+		ar[rT] = append(ar[rT][:0],*values.DerefAV(sr[r1])...)
+	}
+}
+func load_array_from(al arrayLoader, rT int) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		ar := ts.RS.ARegs
+		ar[rT] = append(ar[rT][:0],*al(ts)...)
 	}
 }
 func store_array_unref(r1,rSrc int) vm.InsOp {
 	return func(ts *vm.ThreadState, ip *int, ln int) {
 		sr := ts.RS.SRegs
 		ar := ts.RS.ARegs
-		av := sr[r1].(*values.ScReference).Data.(*values.AV)
+		av := values.DerefAV(sr[r1])
 		*av = append((*av)[:0],ar[rSrc]...)
 	}
 }
@@ -145,7 +211,7 @@ func load_scalar_args(rT int) vm.InsOp {
 		if len(ts.Args)==0 {
 			v = values.Null()
 		} else {
-			v = ts.Args[0]
+			v = ts.Args[len(ts.Args)-1] // (1,2,3) in scalar context yields 3.
 		}
 		ts.RS.SRegs[rT] = v
 	}
@@ -153,7 +219,7 @@ func load_scalar_args(rT int) vm.InsOp {
 func load_array_args(rT int) vm.InsOp {
 	return func(ts *vm.ThreadState, ip *int, ln int) {
 		ar := ts.RS.ARegs
-		ar[rT] = ts.Args
+		ar[rT] = append(make(values.AV,0,len(ts.Args)),ts.Args...) // ts.Args is reused by the next call.
 	}
 }
 /*
@@ -181,19 +247,19 @@ func move_array(r1,rT int) vm.InsOp {
 func load_unref(r1,rT int) vm.InsOp {
 	return func(ts *vm.ThreadState, ip *int, ln int) {
 		sr := ts.RS.SRegs
-		sr[rT] = *(sr[r1].(*values.ScReference).Data.(*values.Scalar))
+		sr[rT] = *values.DerefSV(sr[r1])
 	}
 }
 func store_unref(r1,rSrc int) vm.InsOp {
 	return func(ts *vm.ThreadState, ip *int, ln int) {
 		sr := ts.RS.SRegs
-		*(sr[r1].(*values.ScReference).Data.(*values.Scalar)) = sr[rSrc]
+		*values.DerefSV(sr[r1]) = sr[rSrc]
 	}
 }
 func slot_unref(r1 int) slotLoader {
 	return func(ts *vm.ThreadState) values.ScalarSlot {
 		sr := ts.RS.SRegs
-		return values.MakeScalarSlot(sr[r1].(*values.ScReference).Data.(*values.Scalar))
+		return values.MakeScalarSlot(values.DerefSV(sr[r1]))
 	}
 }
 
@@ -201,34 +267,26 @@ type arrayLoader func(ts *vm.ThreadState) *values.AV
 type hashLoader func(ts *vm.ThreadState) *values.HV
 
 func avglobal(n string, w bool) arrayLoader {
+	gn := newGlobalName(n)
 	if !w {
 		bol := new(values.AV)
 		return func(ts *vm.ThreadState) *values.AV {
-			v,ok := ts.RS.Proc.Parent.Arrays.Load(n)
-			if !ok { v = bol }
-			return v.(*values.AV)
+			if av := gn.array(ts,false); av!=nil { return av }
+			return bol
 		}
 	}
-	return func(ts *vm.ThreadState) *values.AV {
-		v,ok := ts.RS.Proc.Parent.Arrays.Load(n)
-		if !ok { v,_ = ts.RS.Proc.Parent.Arrays.LoadOrStore(n,new(values.AV)) }
-		return v.(*values.AV)
-	}
+	return func(ts *vm.ThreadState) *values.AV { return gn.array(ts,true) }
 }
 func hvglobal(n string, w bool) hashLoader {
+	gn := newGlobalName(n)
 	if !w {
 		bol := new(values.HV)
 		return func(ts *vm.ThreadState) *values.HV {
-			v,ok := ts.RS.Proc.Parent.Hashes.Load(n)
-			if !ok { v = bol }
-			return v.(*values.HV)
+			if hv := gn.hash(ts,false); hv!=nil { return hv }
+			return bol
 		}
 	}
-	return func(ts *vm.ThreadState) *values.HV {
-		v,ok := ts.RS.Proc.Parent.Hashes.Load(n)
-		if !ok { v,_ = ts.RS.Proc.Parent.Hashes.LoadOrStore(n,new(values.HV)) }
-		return v.(*values.HV)
-	}
+	return func(ts *vm.ThreadState) *values.HV { return gn.hash(ts,true) }
 }
 func avlocal(reg int) arrayLoader {
 	return func(ts *vm.ThreadState) *values.AV { return &(ts.RS.ARegs[reg]) }
@@ -237,10 +295,44 @@ func hvlocal(reg int) hashLoader {
 	return func(ts *vm.ThreadState) *values.HV { return &(ts.RS.HRegs[reg]) }
 }
 func avunref(reg int) arrayLoader {
-	return func(ts *vm.ThreadState) *values.AV { return ts.RS.SRegs[reg].(*values.ScReference).Data.(*values.AV) }
+	return func(ts *vm.ThreadState) *values.AV { return values.DerefAV(ts.RS.SRegs[reg]) }
 }
 func hvunref(reg int) hashLoader {
-	return func(ts *vm.ThreadState) *values.HV { return ts.RS.SRegs[reg].(*values.ScReference).Data.(*values.HV) }
+	return func(ts *vm.ThreadState) *values.HV { return values.DerefHV(ts.RS.SRegs[reg]) }
+}
+
+// Like avunref/hvunref, but undef reads as an empty aggregate, without creating one.
+func avpeek(reg int) arrayLoader {
+	bol := new(values.AV)
+	return func(ts *vm.ThreadState) *values.AV {
+		s := ts.RS.SRegs[reg]
+		if s==nil || s.Type()==values.T_Nil { return bol }
+		return values.DerefAV(s)
+	}
+}
+func hvpeek(reg int) hashLoader {
+	bol := new(values.HV)
+	return func(ts *vm.ThreadState) *values.HV {
+		s := ts.RS.SRegs[reg]
+		if s==nil || s.Type()==values.T_Nil { return bol }
+		return values.DerefHV(s)
+	}
+}
+
+// Loads the reference from a slot into rT, autovivifying it, if undefined.
+func vivify_array(sl slotLoader, rT int) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		slot := sl(ts)
+		values.VivifyAV(slot)
+		ts.RS.SRegs[rT] = slot.Get()
+	}
+}
+func vivify_hash(sl slotLoader, rT int) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		slot := sl(ts)
+		values.VivifyHV(slot)
+		ts.RS.SRegs[rT] = slot.Get()
+	}
 }
 
 // arrayLoader
@@ -279,6 +371,13 @@ func load_array(al arrayLoader, r1, rT int) vm.InsOp {
 		scrg[rT] = v
 	}
 }
+func exists_array(al arrayLoader, r1, rT int) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		scrg := ts.RS.SRegs
+		i := scrg[r1].Integer()
+		scrg[rT] = values.Bool2S(i>=0 && i<int64(len(*al(ts))))
+	}
+}
 func length_array(al arrayLoader, rT int) vm.InsOp {
 	return func(ts *vm.ThreadState, ip *int, ln int) {
 		av := al(ts)
@@ -286,6 +385,16 @@ func length_array(al arrayLoader, rT int) vm.InsOp {
 		scrg[rT] = values.ScInt(len(*av))
 	}
 }
+func last_index(al arrayLoader, rT int) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		ts.RS.SRegs[rT] = values.ScInt(len(*al(ts))-1)
+	}
+}
+func length_hash(hl hashLoader, rT int) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		ts.RS.SRegs[rT] = values.ScInt(hl(ts).Len())
+	}
+}
 
 func store_array(al arrayLoader, r1, rSrc int) vm.InsOp {
 	return func(ts *vm.ThreadState, ip *int, ln int) {
@@ -301,6 +410,14 @@ func slot_array(al arrayLoader, r1 int) slotLoader {
 		return av.StoreSlot(scrg[r1].Integer())
 	}
 }
+// Like slot_array, but nil, if the element doesn't exist.
+func slot_array_peek(al arrayLoader, r1 int) slotLoader {
+	return func(ts *vm.ThreadState) values.ScalarSlot {
+		p := al(ts).FetchUp(ts.RS.SRegs[r1].Integer(),false)
+		if p==nil || *p==nil { return nil }
+		return values.MakeScalarSlot(p)
+	}
+}
 
 func load_hash(hl hashLoader, r1, rT int) vm.InsOp {
 	return func(ts *vm.ThreadState, ip *int, ln int) {
@@ -321,6 +438,12 @@ func store_hash(hl hashLoader, r1, rSrc int) vm.InsOp {
 		hv.Put(scrg[r1]).Set(scrg[rSrc])
 	}
 }
+func exists_hash(hl hashLoader, r1, rT int) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		scrg := ts.RS.SRegs
+		scrg[rT] = values.Bool2S(hl(ts).Get(scrg[r1])!=nil)
+	}
+}
 func slot_hash(hl hashLoader, r1 int) slotLoader {
 	return func(ts *vm.ThreadState) values.ScalarSlot {
 		hv := hl(ts)
@@ -328,6 +451,12 @@ func slot_hash(hl hashLoader, r1 int) slotLoader {
 		return hv.Put(scrg[r1])
 	}
 }
+// Like slot_hash, but nil, if the element doesn't exist.
+func slot_hash_peek(hl hashLoader, r1 int) slotLoader {
+	return func(ts *vm.ThreadState) values.ScalarSlot {
+		return hl(ts).Get(ts.RS.SRegs[r1])
+	}
+}
 
 func scratch_clear(rs int) vm.InsOp {
 	return func(ts *vm.ThreadState, ip *int, ln int) {
@@ -340,6 +469,11 @@ func scratch_null(rs int) vm.InsOp {
 		ts.RS.ARegs[rs] = nil
 	}
 }
+func hash_null(rs int) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		ts.RS.HRegs[rs] = values.HV{}
+	}
+}
 func scratch_init(rs, r1 int) vm.InsOp {
 	return func(ts *vm.ThreadState, ip *int, ln int) {
 		ARS := ts.RS.ARegs
@@ -364,7 +498,7 @@ func scratch_shift_scalar(rs, r1 int) vm.InsOp {
 	return func(ts *vm.ThreadState, ip *int, ln int) {
 		ARS := ts.RS.ARegs
 		v := values.Null()
-		if len(ARS)>0 {
+		if len(ARS[rs])>0 {
 			v = ARS[rs][0]
 			ARS[rs] = ARS[rs][1:]
 		}
@@ -418,22 +552,31 @@ var binop_map = map[string]binop_t {
 	"*": values.Mul,
 	"/": values.Div,
 	"%": values.Mod,
+	"**": values.Pow,
 	".": values.Concat,
+	"&": values.BitAnd,
+	"|": values.BitOr,
+	"^": values.BitXor,
+	"<<": values.ShiftLeft,
+	">>": values.ShiftRight,
 	
 	"and": values.And,
 	"or": values.Or,
-	"gt": values.GT,
-	">": values.GT,
-	"lt": values.LT,
-	"<": values.LT,
-	"ge": values.GE,
-	">=": values.GE,
-	"le": values.LE,
-	"<=": values.LE,
-	"eq": values.EQ,
-	"==": values.EQ,
-	"ne": values.NE,
-	"!=": values.NE,
+	
+	"==": values.NumEQ,
+	"!=": values.NumNE,
+	"<": values.NumLT,
+	">": values.NumGT,
+	"<=": values.NumLE,
+	">=": values.NumGE,
+	"<=>": values.NumComp,
+	"eq": values.StrEQ,
+	"ne": values.StrNE,
+	"lt": values.StrLT,
+	"gt": values.StrGT,
+	"le": values.StrLE,
+	"ge": values.StrGE,
+	"cmp": values.StrComp,
 }
 
 func binop(op binop_t, r1, r2, rT int) vm.InsOp {
@@ -467,99 +610,345 @@ func unop(op unop_t, r1, rT int) vm.InsOp {
 	}
 }
 
+/* ++ and --: updates the slot. rT receives the new value, or the old one (post). */
+func incdec(op unop_t, sl slotLoader, post, inc bool, rT int) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		slot := sl(ts)
+		old := slot.Get()
+		nv := op(old)
+		slot.Set(nv)
+		if !post {
+			ts.RS.SRegs[rT] = nv
+		} else if inc && old.Type()==values.T_Nil {
+			ts.RS.SRegs[rT] = values.ScInt(0) // undef++ yields 0, undef-- undef
+		} else {
+			ts.RS.SRegs[rT] = old
+		}
+	}
+}
 
-func regex_match(rx *regexp.Regexp, r1, rT int, regs []int) vm.InsOp {
+// Matches vs against rx. On success, the match becomes the current one ($&, $1, ...).
+func rx_match(ts *vm.ThreadState, rx values.Regex, vs values.Scalar) bool {
+	str := vs.String()
+	loc := rx.FindAt(str,0)
+	if loc==nil { return false }
+	rx_setmatch(ts,rx,str,loc,vs.IsBytes())
+	return true
+}
+func regex_match(rx values.Regex, r1, rT int) vm.InsOp {
 	return func(ts *vm.ThreadState, ip *int, ln int) {
 		sr := ts.RS.SRegs
-		vs := sr[r1]
 		sr[rT] = values.Null()
-		if vs.IsBytes() {
-			res := rx.FindSubmatch(vs.Bytes())
-			if len(res)==0 { return }
-			for i,v := range res {
-				sr[regs[i]] = values.ScBuffer(v)
-			}
-		} else {
-			res := rx.FindStringSubmatch(vs.String())
-			if len(res)==0 { return }
-			for i,v := range res {
-				sr[regs[i]] = values.ScString(v)
-			}
+		if rx_match(ts,rx,sr[r1]) { sr[rT] = values.Bool2S(true) }
+	}
+}
+/*
+Matches against a runtime pattern (a qr// object or a string) in rP. A string is compiled
+with the given engine (see "use re").
+*/
+func regex_match_dyn(engine string, rP, r1, rT int) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		sr := ts.RS.SRegs
+		rx := values.CompileRegex(sr[rP],engine)
+		sr[rT] = values.Null()
+		if rx_match(ts,rx,sr[r1]) { sr[rT] = values.Bool2S(true) }
+	}
+}
+func rx_value(s string, bytes bool) values.Scalar {
+	if bytes { return values.ScBuffer(s) }
+	return values.ScString(s)
+}
+// Makes a match (as returned by FindStringSubmatchIndex) the current one.
+func rx_setmatch(ts *vm.ThreadState, rx values.Regex, str string, loc []int, bytes bool) {
+	ts.RS.Match = &values.Match{Subject: str, Bytes: bytes, Loc: loc, Names: rx.SubexpNames()}
+}
+
+// $&, $1..., $` and $' read the current match.
+func match_group(n int, rT int) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		ts.RS.SRegs[rT] = ts.RS.Match.Group(n)
+	}
+}
+func match_prematch(rT int) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		ts.RS.SRegs[rT] = ts.RS.Match.Prematch()
+	}
+}
+func match_postmatch(rT int) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		ts.RS.SRegs[rT] = ts.RS.Match.Postmatch()
+	}
+}
+// @- and @+
+func avmatch(ends bool) arrayLoader {
+	return func(ts *vm.ThreadState) *values.AV { return ts.RS.Match.Offsets(ends) }
+}
+// %+ and %-
+func hvmatch(all bool) hashLoader {
+	return func(ts *vm.ThreadState) *values.HV { return ts.RS.Match.Named(all) }
+}
+
+/*
+Finds the next match at or after start. An empty match is not accepted at the position,
+where the previous match was empty, as m//g would never advance otherwise.
+*/
+func rx_find(rx values.Regex, str string, start int, empty bool) []int {
+	loc := rx.FindAt(str,start)
+	if loc!=nil && empty && loc[1]==start {
+		if start==len(str) { return nil }
+		_,w := utf8.DecodeRuneInString(str[start:])
+		loc = rx.FindAt(str,start+w)
+	}
+	return loc
+}
+func rx_subject(ts *vm.ThreadState, sl slotLoader, r1 int) (slot values.ScalarSlot, vs values.Scalar, start int, empty bool) {
+	if sl==nil { return nil,ts.RS.SRegs[r1],0,false }
+	slot = sl(ts)
+	if slot==nil { return nil,values.Null(),0,false }
+	vs = slot.Get()
+	start,empty = ts.Pos.Get(slot)
+	if start<0 { start = 0 }
+	return
+}
+func rx_setpos(ts *vm.ThreadState, slot values.ScalarSlot, pos int, empty bool) {
+	if slot==nil { return }
+	if ts.Pos==nil { ts.Pos = make(values.PosTable) }
+	ts.Pos.Set(slot,pos,empty)
+}
+
+/*
+m//g in scalar context: finds the next match, starting at pos() of the matched variable.
+If sl is nil, the operand in r1 is a temporary value and always matched from the start.
+*/
+func regex_match_iter(rx values.Regex, sl slotLoader, r1, rT int) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		sr := ts.RS.SRegs
+		slot,vs,start,empty := rx_subject(ts,sl,r1)
+		str := vs.String()
+		loc := rx_find(rx,str,start,empty)
+		if loc==nil {
+			rx_setpos(ts,slot,-1,false)
+			sr[rT] = values.Null()
+			return
 		}
+		rx_setmatch(ts,rx,str,loc,vs.IsBytes())
+		rx_setpos(ts,slot,loc[1],loc[0]==loc[1])
 		sr[rT] = values.Bool2S(true)
 	}
 }
-
-func regex_replace(rx *regexp.Regexp, r1, r2, rT int) vm.InsOp {
+/*
+m//g in list context: returns all matches, or all groups of all matches, if the regex has groups.
+*/
+func regex_match_all(rx values.Regex, sl slotLoader, r1, rT int) vm.InsOp {
+	ngroups := rx.NumSubexp()
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		slot,vs,start,empty := rx_subject(ts,sl,r1)
+		str := vs.String()
+		bytes := vs.IsBytes()
+		res := make(values.AV,0,4)
+		for {
+			loc := rx_find(rx,str,start,empty)
+			if loc==nil { break }
+			if ngroups==0 {
+				res = append(res,rx_value(str[loc[0]:loc[1]],bytes))
+			} else {
+				for i := 1; i<=ngroups; i++ {
+					if loc[2*i]<0 { res = append(res,values.Null()); continue }
+					res = append(res,rx_value(str[loc[2*i]:loc[2*i+1]],bytes))
+				}
+			}
+			rx_setmatch(ts,rx,str,loc,bytes)
+			start,empty = loc[1],loc[0]==loc[1]
+		}
+		rx_setpos(ts,slot,-1,false)
+		ts.RS.ARegs[rT] = res
+	}
+}
+func pos_get(sl slotLoader, rT int) vm.InsOp {
 	return func(ts *vm.ThreadState, ip *int, ln int) {
 		sr := ts.RS.SRegs
-		vs := sr[r1]
-		repl := sr[r2]
-		if vs.IsBytes() {
-			sr[rT] = values.ScBuffer(rx.ReplaceAll(vs.Bytes(),repl.Bytes()))
+		sr[rT] = values.Null()
+		slot := sl(ts)
+		if slot==nil { return }
+		if pos,_ := ts.Pos.Get(slot); pos>=0 {
+			if v := slot.Get(); !v.IsBytes() { pos = values.CharOffset(v.String(),pos) }
+			sr[rT] = values.ScInt(pos)
+		}
+	}
+}
+func pos_set(sl slotLoader, r1 int) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		v := ts.RS.SRegs[r1]
+		slot := sl(ts)
+		if v.Type()==values.T_Nil {
+			rx_setpos(ts,slot,-1,false)
+		} else if s := slot.Get(); !s.IsBytes() {
+			rx_setpos(ts,slot,values.ByteOffset(s.String(),int(v.Integer())),false)
 		} else {
-			sr[rT] = values.ScString(rx.ReplaceAllString(vs.String(),repl.String()))
+			rx_setpos(ts,slot,int(v.Integer()),false)
 		}
 	}
 }
 
-func noop(ts *vm.ThreadState, ip *int, ln int) {
-}
-func last(ts *vm.ThreadState, ip *int, ln int) {
-	ts.Flags |= vm.TSF_Last
-	*ip = ln
-}
-func next(ts *vm.ThreadState, ip *int, ln int) {
-	*ip = ln
+func regex_ref(rx values.Regex, rT int) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		ts.RS.SRegs[rT] = values.NewRegexRef(rx)
+	}
 }
-func loop(slice []vm.InsOp) vm.InsOp {
+
+/*
+s///: replaces the first match (or every match, if global) with the value of the replacement,
+which is evaluated for each match, with the match being the current one.
+If sl is nil, the operand is in r1 and the result is the new string (s///r). Otherwise the
+operand is updated and the result is the number of substitutions.
+*/
+func regex_replace(rx values.Regex, global bool, sl slotLoader, r1 int, repl []vm.InsOp, r2, rT int) vm.InsOp {
 	return func(ts *vm.ThreadState, ip *int, ln int) {
+		sr := ts.RS.SRegs
+		var slot values.ScalarSlot
+		var vs values.Scalar
+		if sl!=nil {
+			slot = sl(ts)
+			vs = slot.Get()
+		} else {
+			vs = sr[r1]
+		}
+		str := vs.String()
+		bytes := vs.IsBytes()
+		
+		var b strings.Builder
+		n,last,start,empty := 0,0,0,false
 		for {
-			ts.RunSlice(slice)
-			if (ts.Flags & vm.TSF_Last)!=0 { break }
+			loc := rx_find(rx,str,start,empty)
+			if loc==nil { break }
+			rx_setmatch(ts,rx,str,loc,bytes)
+			ts.RunSlice(repl)
+			b.WriteString(str[last:loc[0]])
+			b.WriteString(sr[r2].String())
+			last = loc[1]
+			n++
+			if !global { break }
+			start,empty = loc[1],loc[0]==loc[1]
+		}
+		b.WriteString(str[last:])
+		
+		if slot==nil {
+			if n==0 { sr[rT] = vs } else { sr[rT] = rx_value(b.String(),bytes) }
+			return
 		}
-		ts.Flags &= ^vm.TSF_Last
-		if (ts.Flags & vm.TSF_Return)!=0 { *ip = ln }
+		if n==0 {
+			sr[rT] = values.Bool2S(false)
+			return
+		}
+		slot.Set(rx_value(b.String(),bytes))
+		sr[rT] = values.ScInt(n)
 	}
 }
 
-func runsecdefer(ts *vm.ThreadState,rT int) {
-	rec := recover()
-	v := values.Null()
-	if rec!=nil {
-		v = values.ForceTrue(values.ScString(fmt.Sprint(rec)))
+/*
+tr///: transliterates the operand. With tr///r, the operand is in r1 and the result is the
+new value. Otherwise the operand is updated (unless nothing is replaced) and the result is
+the number of characters matched. Counting only (tr/a-z//) works on any value in r1.
+*/
+func transliterate(tr *values.Trans, sl slotLoader, r1, rT int, ret bool) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		sr := ts.RS.SRegs
+		if sl==nil {
+			nv,n := tr.Scalar(sr[r1])
+			if ret { sr[rT] = nv } else { sr[rT] = values.ScInt(n) }
+			return
+		}
+		slot := sl(ts)
+		nv,n := tr.Scalar(slot.Get())
+		if !tr.Identity { slot.Set(nv) }
+		sr[rT] = values.ScInt(n)
 	}
-	ts.RS.SRegs[rT] = v
 }
-func runsec(ts *vm.ThreadState,rT int, slice []vm.InsOp) {
-	defer runsecdefer(ts,rT)
-	ts.RunSlice(slice)
+
+func noop(ts *vm.ThreadState, ip *int, ln int) {
+}
+// next, last and redo. The flag is consumed by the loop, the label refers to.
+func loop_jump(flag uint, label string) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		ts.Flags |= flag
+		ts.Label = label
+		*ip = ln
+	}
 }
 
-func eval(rT int, slice []vm.InsOp) vm.InsOp {
+const (
+	lc_none = iota
+	lc_next
+	lc_last
+	lc_redo
+	lc_leave // return, or a jump to an outer loop.
+)
+
+// Checks, whether the loop named label has to handle a next, last or redo.
+func loop_control(ts *vm.ThreadState, label string) int {
+	f := ts.Flags & (vm.TSF_LoopCtl|vm.TSF_Return)
+	if f==0 { return lc_none }
+	if (f&vm.TSF_Return)!=0 { return lc_leave }
+	if ts.Label!="" && ts.Label!=label { return lc_leave }
+	ts.Flags &= ^vm.TSF_LoopCtl
+	ts.Label = ""
+	switch f {
+	case vm.TSF_Next: return lc_next
+	case vm.TSF_Redo: return lc_redo
+	}
+	return lc_last
+}
+
+// LABEL: while(cond) {body}
+func loop(label string, cond []vm.InsOp, rc int, body []vm.InsOp) vm.InsOp {
 	return func(ts *vm.ThreadState, ip *int, ln int) {
-		runsec(ts,rT,slice)
-		if (ts.Flags & (vm.TSF_Last|vm.TSF_Return))!=0 { *ip = ln }
+		for {
+			ts.RunSlice(cond)
+			if !ts.RS.SRegs[rc].Bool() { break }
+		redo:
+			ts.RunSlice(body)
+			switch loop_control(ts,label) {
+			case lc_redo: goto redo
+			case lc_last: return
+			case lc_leave:
+				*ip = ln
+				return
+			}
+		}
 	}
 }
 
-// for $sr (@ar) {slice}
-func loop_for_s(ar, sr int, slice []vm.InsOp) vm.InsOp {
-	return loop_for(avlocal(ar),sr,slice)
+// eval {...}: $@ is the error, with the position it was raised at, or undef.
+func eval(rT int, slice []vm.InsOp) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		v := values.Null()
+		switch rec := ts.RunProtected(slice).(type) {
+		case nil:
+		case *vm.RuntimeError: v = values.ForceTrue(values.ScString(rec.Error()))
+		default: v = values.ForceTrue(values.ScString(fmt.Sprint(rec)))
+		}
+		ts.RS.SRegs[rT] = v
+		if (ts.Flags & (vm.TSF_LoopCtl|vm.TSF_Return))!=0 { *ip = ln }
+	}
 }
 
-// for $sr (@ar) {slice}
-func loop_for(al arrayLoader, sr int, slice []vm.InsOp) vm.InsOp {
+// LABEL: for $sr (@ar) {slice}
+func loop_for(label string, al arrayLoader, sr int, slice []vm.InsOp) vm.InsOp {
 	return func(ts *vm.ThreadState, ip *int, ln int) {
 		av := *al(ts)
 		sv := &ts.RS.SRegs[sr]
 		for i,n := 0,len(av); i<n; i++ {
 			*sv = av[i]
+		redo:
 			ts.RunSlice(slice)
-			if (ts.Flags & vm.TSF_Last)!=0 { break }
+			switch loop_control(ts,label) {
+			case lc_redo: goto redo
+			case lc_last: return
+			case lc_leave:
+				*ip = ln
+				return
+			}
 		}
-		ts.Flags &= ^vm.TSF_Last
-		if (ts.Flags & vm.TSF_Return)!=0 { *ip = ln }
 	}
 }
 
@@ -584,22 +973,72 @@ func jump_unless(off, cond int) vm.InsOp {
 	}
 }
 
-func subcall(name string) vm.InsOp {
+// Skips the scalar-context variant of a return expression.
+func jump_if_list(off int) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		if ts.RS.Context==vm.CTX_List {
+			*ip += off
+		}
+	}
+}
+
+func return_scalar(r1 int) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		ts.Args = append(ts.Args[:0],ts.RS.SRegs[r1])
+		ts.Flags |= vm.TSF_Return
+		*ip = ln
+	}
+}
+func return_array(r1 int) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		ts.Args = append(ts.Args[:0],ts.RS.ARegs[r1]...)
+		ts.Flags |= vm.TSF_Return
+		*ip = ln
+	}
+}
+func return_empty(ts *vm.ThreadState, ip *int, ln int) {
+	ts.Args = ts.Args[:0]
+	ts.Flags |= vm.TSF_Return
+	*ip = ln
+}
+
+// wantarray: true in list-, false in scalar- and undef in void context.
+func wantarray(rT int) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		var v values.Scalar
+		switch ts.RS.Context {
+		case vm.CTX_List: v = values.ScInt(1)
+		case vm.CTX_Scalar: v = values.ScInt(0)
+		default: v = values.Null()
+		}
+		ts.RS.SRegs[rT] = v
+	}
+}
+
+func subcall(name string, pos scanner.Position, ctx int) vm.InsOp {
+	gn := newGlobalName(name)
 	return func(ts *vm.ThreadState, ip *int, ln int) {
-		v,ok := ts.RS.Proc.Parent.Procedures.Load(name)
-		if !ok { panic("not found: sub "+ts.RS.Proc.Parent.Name+"::"+name) }
+		md := gn.module(ts)
+		v,ok := md.Procedures.Load(gn.Name)
+		if !ok { panic("not found: sub "+md.Name+"::"+gn.Name) }
+		ts.CallPos = pos
+		ts.Context = ctx
 		v.(*vm.Procedure).Exec(ts)
 	}
 }
-func subcallgo(name string) vm.InsOp {
+func subcallgo(name string, pos scanner.Position, ctx int) vm.InsOp {
+	gn := newGlobalName(name)
 	return func(ts *vm.ThreadState, ip *int, ln int) {
-		v,ok := ts.RS.Proc.Parent.Procedures.Load(name)
-		if !ok { panic("not found: sub "+ts.RS.Proc.Parent.Name+"::"+name) }
+		md := gn.module(ts)
+		v,ok := md.Procedures.Load(gn.Name)
+		if !ok { panic("not found: sub "+md.Name+"::"+gn.Name) }
+		ts.CallPos = pos
+		ts.Context = ctx
 		ts.GoExec(v.(*vm.Procedure))
 	}
 }
 
-func modcall(r1 int, name string) vm.InsOp {
+func modcall(r1 int, name string, pos scanner.Position, ctx int) vm.InsOp {
 	return func(ts *vm.ThreadState, ip *int, ln int) {
 		sc := ts.RS.SRegs[r1]
 		mod := values.GetScModule(sc)
@@ -608,11 +1047,13 @@ func modcall(r1 int, name string) vm.InsOp {
 		if !ok { panic("Module not fond: "+mod.String()) }
 		v,ok := mod2.Procedures.Load(name)
 		if !ok { panic("not found: sub "+mod2.Name+"::"+name) }
+		ts.CallPos = pos
+		ts.Context = ctx
 		v.(*vm.Procedure).Exec(ts)
 	}
 }
 
-func modcallgo(r1 int, name string) vm.InsOp {
+func modcallgo(r1 int, name string, pos scanner.Position, ctx int) vm.InsOp {
 	return func(ts *vm.ThreadState, ip *int, ln int) {
 		sc := ts.RS.SRegs[r1]
 		mod := values.GetScModule(sc)
@@ -621,8 +1062,59 @@ func modcallgo(r1 int, name string) vm.InsOp {
 		if !ok { panic("Module not fond: "+mod.String()) }
 		v,ok := mod2.Procedures.Load(name)
 		if !ok { panic("not found: sub "+mod2.Name+"::"+name) }
+		ts.CallPos = pos
+		ts.Context = ctx
 		ts.GoExec(v.(*vm.Procedure))
 	}
 }
 
-
+// sub name($a, $b = 1, @rest) {...}
+func sig_check(name string, min, max int) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		n := len(ts.Args)
+		if n<min { panic(fmt.Sprintf("Too few arguments for sub %s",name)) }
+		if max>=0 && n>max { panic(fmt.Sprintf("Too many arguments for sub %s",name)) }
+	}
+}
+func sig_scalar(al arrayLoader, i, rT int) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		ts.RS.SRegs[rT] = (*al(ts))[i]
+	}
+}
+// Skips the default value, if the argument is present.
+func sig_scalar_default(al arrayLoader, i, rT, off int) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		av := *al(ts)
+		if len(av)>i {
+			ts.RS.SRegs[rT] = av[i]
+			*ip += off
+		}
+	}
+}
+func sig_array(al arrayLoader, i, rT int) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		ARS := ts.RS.ARegs
+		av := *al(ts)
+		j := i
+		if len(av)<j { j = len(av) }
+		ARS[rT] = append(ARS[rT][:0],av[j:]...)
+	}
+}
+func sig_hash(name string, al arrayLoader, i, rT int) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		av := *al(ts)
+		j := i
+		if len(av)<j { j = len(av) }
+		rest := av[j:]
+		if len(rest)%2 != 0 { panic(fmt.Sprintf("Odd name/value argument for sub %s",name)) }
+		hv := &ts.RS.HRegs[rT]
+		hv.Clear()
+		hv.FromAV(&rest)
+	}
+}
+func copy_array_args(rT int) vm.InsOp {
+	return func(ts *vm.ThreadState, ip *int, ln int) {
+		ar := ts.RS.ARegs
+		ar[rT] = append(ar[rT][:0],ts.Args...)
+	}
+}
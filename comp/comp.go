@@ -26,14 +26,32 @@ package comp
 import "github.com/byte-mug/dream/astparser"
 import "github.com/byte-mug/dream/values"
 import "github.com/byte-mug/dream/vm"
-import "regexp"
+import "github.com/byte-mug/dream/backtrack"
+import "text/scanner"
+import "strings"
+import "strconv"
 import "fmt"
 
 type intAlloc struct {
-	defined map[string]bool
 	named map[string]int
 	temp map[int]int
 	count map[int]int
+	scopes []*allocScope
+	free []int // registers of variables, whose scope has ended
+}
+
+/* A lexical scope ({ ... }). The first scope is the one of the procedure itself. */
+type allocScope struct {
+	declared map[string]bool
+	shadowed map[string]int // name -> outer register, -1 if unbound
+	regs []int
+}
+func (sc *allocScope) shadow(ia *intAlloc, s string) {
+	if sc.shadowed==nil { sc.shadowed = make(map[string]int) }
+	if _,ok := sc.shadowed[s]; ok { return }
+	r,ok := ia.named[s]
+	if !ok { r = -1 }
+	sc.shadowed[s] = r
 }
 func (ia *intAlloc) getTemp() (int,bool) {
 	if ia.count==nil { ia.count = make(map[int]int) }
@@ -80,14 +98,73 @@ func (ia *intAlloc) setDefined(s string, r int) {
 	ia.named[s] = r
 }
 func (ia *intAlloc) doDefine(s string, sigil string) {
-	if ia.defined==nil { ia.defined = make(map[string]bool) }
-	if ia.defined[s] { panic("Varialbe already declared: "+sigil+s) }
-	ia.defined[s] = true
+	sc := ia.scope()
+	if sc.declared==nil { sc.declared = make(map[string]bool) }
+	if sc.declared[s] { panic("Varialbe already declared: "+sigil+s) }
+	sc.declared[s] = true
+}
+func (ia *intAlloc) undefine(s string) {
+	sc := ia.scope()
+	sc.shadow(ia,s)
+	delete(sc.declared,s)
+	delete(ia.named,s)
+}
+func (ia *intAlloc) scope() *allocScope {
+	if len(ia.scopes)==0 { ia.enter() }
+	return ia.scopes[len(ia.scopes)-1]
+}
+func (ia *intAlloc) enter() {
+	ia.scopes = append(ia.scopes,new(allocScope))
+}
+func (ia *intAlloc) leave() {
+	sc := ia.scope()
+	ia.scopes = ia.scopes[:len(ia.scopes)-1]
+	for s,r := range sc.shadowed {
+		if r<0 { delete(ia.named,s) } else { ia.named[s] = r }
+	}
+	ia.free = append(ia.free,sc.regs...)
+}
+// binds s to r in the scope sc, hiding any outer binding until sc ends.
+func (ia *intAlloc) bind(sc *allocScope, s string, r int) {
+	sc.shadow(ia,s)
+	sc.regs = append(sc.regs,r)
+	ia.setDefined(s,r)
+}
+func (ia *intAlloc) getFree() (int,bool) {
+	n := len(ia.free)
+	if n==0 { return 0,false }
+	r := ia.free[n-1]
+	ia.free = ia.free[:n-1]
+	return r,true
 }
 
 type Alloc struct {
 	RSM vm.RSMetrics
 	mgmt [vm.RSM_NumberOf]intAlloc
+	
+	Module string // name of the module, the procedure belongs to
+	Package string // current package (package Foo;)
+	ours map[string]string // our $x (sigil+name -> qualified name)
+	oscopes []map[string]string // ours of the enclosing scopes
+	strict *strictDecls // nil, unless "use strict;"
+	rxEngine string // use re qw(engine);
+	utf8 bool // use utf8;
+	loops []string // labels of the enclosing loops
+	blocks map[*vm.InsOp]*vm.LineTable // see vm.Procedure.Blocks
+	subOurs map[*astparser.MDSub]map[string]string // the "our" declarations, a sub definition sees
+}
+
+// Records the source positions of a nested slice of instructions (a loop body, ...).
+func (a *Alloc) block(code []vm.InsOp, lt *vm.LineTable) {
+	if len(code)==0 || len(lt.Start)==0 { return }
+	if a.blocks==nil { a.blocks = make(map[*vm.InsOp]*vm.LineTable) }
+	a.blocks[&code[0]] = lt
+}
+func (a *Alloc) inLoop(label string) bool {
+	for _,l := range a.loops {
+		if l==label { return true }
+	}
+	return false
 }
 func (a *Alloc) temp(t int) int {
 	var r int
@@ -121,14 +198,45 @@ func (a *Alloc) add(t,r,c int) {
 func (a *Alloc) defined(t int,s string) (int,bool) {
 	return a.mgmt[t].getDefined(s)
 }
+/*
+Declares a lexical variable. An explicit declaration (my $x) gets a new register in the
+current scope, shadowing any outer $x. An implicit one (for $x (...), $@) reuses a visible
+$x, or is bound to the scope of the procedure.
+*/
 func (a *Alloc) define(t int,s string, sigil string) {
-	if sigil!="" { a.mgmt[t].doDefine(s,sigil) }
-	_,ok := a.mgmt[t].getDefined(s)
+	ia := &a.mgmt[t]
+	sc := ia.scope()
+	if sigil!="" {
+		ia.doDefine(s,sigil)
+	} else {
+		if _,ok := ia.getDefined(s); ok { return }
+		sc = ia.scopes[0]
+	}
+	r,ok := ia.getFree()
 	if !ok {
-		r := a.RSM[t]
+		r = a.RSM[t]
 		a.RSM[t] = r+1
-		a.mgmt[t].setDefined(s,r)
 	}
+	ia.bind(sc,s,r)
+}
+/*
+Opens a lexical scope ({ ... }). The registers of the variables declared within it
+are reused, once the scope is closed by Leave.
+*/
+func (a *Alloc) Enter() {
+	for t := range a.mgmt { a.mgmt[t].enter() }
+	a.oscopes = append(a.oscopes,a.ours)
+	if len(a.ours)!=0 {
+		ours := make(map[string]string,len(a.ours))
+		for k,v := range a.ours { ours[k] = v }
+		a.ours = ours
+	}
+}
+func (a *Alloc) Leave() {
+	for t := range a.mgmt { a.mgmt[t].leave() }
+	n := len(a.oscopes)-1
+	a.ours = a.oscopes[n]
+	a.oscopes = a.oscopes[:n]
 }
 
 
@@ -204,6 +312,84 @@ func (a *Alloc) MyDefine(s string) {
 	case '%': a.SetHsDefine(s[1:])
 	}
 }
+/*
+Declares a package variable (our $x) and returns its qualified name. Any lexical
+variable of the same name is hidden, so that later references resolve to the global.
+*/
+func (a *Alloc) OurDefine(s string) string {
+	switch s[0] {
+	case '$': a.mgmt[vm.RSM_Scalar].undefine(s[1:])
+	case '@': a.mgmt[vm.RSM_Array].undefine(s[1:])
+	case '%': a.mgmt[vm.RSM_Hash].undefine(s[1:])
+	}
+	if a.ours==nil { a.ours = make(map[string]string) }
+	q := a.Qualify(s[1:])
+	a.ours[s] = q
+	return s[:1]+q
+}
+/*
+Qualifies the name of a global variable or a sub with the current package,
+unless it is already qualified or the package is the module itself.
+*/
+func (a *Alloc) Qualify(s string) string {
+	if a.Package=="" || a.Package==a.Module { return s }
+	if strings.Contains(s,"::") { return s }
+	return a.Package+"::"+s
+}
+func (a *Alloc) global(s string, sigil string, pos scanner.Position) string {
+	if q,ok := a.ours[sigil+s]; ok { return q }
+	q := a.Qualify(s)
+	if a.strict!=nil && !strictExempt(s) && !a.strict.vars[sigil+a.fullName(q)] {
+		panic(fmt.Errorf("%v : Global symbol \"%s%s\" requires explicit package name",pos,sigil,s))
+	}
+	return q
+}
+func (a *Alloc) GetScGlobal(s string, pos scanner.Position) string { return a.global(s,"$",pos) }
+func (a *Alloc) GetArGlobal(s string, pos scanner.Position) string { return a.global(s,"@",pos) }
+func (a *Alloc) GetHsGlobal(s string, pos scanner.Position) string { return a.global(s,"%",pos) }
+func (a *Alloc) fullName(q string) string {
+	if strings.Contains(q,"::") { return q }
+	return a.Module+"::"+q
+}
+/*
+Reports calls to subs, that are neither defined in the module nor imported, in strict mode.
+Calls into other modules (O::f) aren't checked, as these are only loaded at runtime.
+*/
+func (a *Alloc) checkSub(q string, pos scanner.Position) {
+	if a.strict==nil || a.strict.subs[a.fullName(q)] { return }
+	panic(fmt.Errorf("%v : Undefined subroutine &%s called",pos,a.fullName(q)))
+}
+
+/*
+The declarations, strict mode checks against. They are shared by the procedures of a
+module, so that the subs see the imports of the module body. Variables declared with
+"our" are lexically scoped and tracked by Alloc.ours instead.
+*/
+type strictDecls struct {
+	vars map[string]bool // sigil + fully qualified name
+	subs map[string]bool // fully qualified name
+}
+func newStrictDecls() *strictDecls {
+	return &strictDecls{make(map[string]bool),make(map[string]bool)}
+}
+func (sd *strictDecls) imported(mod string, names []string) {
+	for _,n := range names {
+		switch n[0] {
+		case '$','@','%': sd.vars[n[:1]+mod+"::"+n[1:]] = true
+		default: sd.subs[mod+"::"+n] = true
+		}
+	}
+}
+
+// Qualified names and special variables ($_, $0, $1, %ENV, ...) need no declaration.
+func strictExempt(s string) bool {
+	if strings.Contains(s,"::") { return true }
+	switch s {
+	case "_","a","b","ENV","ARGV","INC","STDIN","STDOUT","STDERR": return true
+	}
+	c := s[0]
+	return !(c=='_' || ('a'<=c && c<='z') || ('A'<=c && c<='Z'))
+}
 // -------------------------------
 type shiftFrom int
 func (shiftFrom) IsHybrid() {}
@@ -211,30 +397,72 @@ func (shiftFrom) IsHybrid() {}
 type scalarReg int
 
 // -------------------------------
-func compileArrayLoader(alloc *Alloc, name interface{}, w bool) (ops []vm.InsOp, al arrayLoader, reg int) {
+func compileArrayLoader(alloc *Alloc, name interface{}, w bool, pos scanner.Position) (ops []vm.InsOp, al arrayLoader, reg int) {
 	if str,ok := name.(string); ok {
 		if str=="_" { return nil,avargs,-1 }
+		if str=="-" || str=="+" { return nil,avmatch(str=="+"),-1 }
 		if areg,ok := alloc.GetArDefined(str); ok {
 			return nil,avlocal(areg),-1
 		}
-		return nil,avglobal(str,w),-1
+		return nil,avglobal(alloc.GetArGlobal(str,pos),w),-1
+	}
+	if w {
+		ops,reg = compileVivify(alloc,name,false)
+	} else {
+		ops,reg = ScCompile(alloc,name,ScAny)
 	}
-	ops,reg = ScCompile(alloc,name,ScAny)
 	al = avunref(reg)
 	return
 }
-func compileHashLoader(alloc *Alloc, name interface{}, w bool) (ops []vm.InsOp, al hashLoader, reg int) {
+func compileHashLoader(alloc *Alloc, name interface{}, w bool, pos scanner.Position) (ops []vm.InsOp, al hashLoader, reg int) {
 	if str,ok := name.(string); ok {
-		if areg,ok := alloc.GetArDefined(str); ok {
-			return nil,hvlocal(areg),-1
+		if str=="+" || str=="-" { return nil,hvmatch(str=="-"),-1 }
+		if hreg,ok := alloc.GetHsDefined(str); ok {
+			return nil,hvlocal(hreg),-1
 		}
-		return nil,hvglobal(str,w),-1
+		return nil,hvglobal(alloc.GetHsGlobal(str,pos),w),-1
+	}
+	if w {
+		ops,reg = compileVivify(alloc,name,true)
+	} else {
+		ops,reg = ScCompile(alloc,name,ScAny)
 	}
-	ops,reg = ScCompile(alloc,name,ScAny)
 	al = hvunref(reg)
 	return
 }
 
+/*
+Like compileArrayLoader and compileHashLoader, but for reading elements:
+An undefined reference reads as an empty array or hash, without creating one.
+*/
+func compileArrayPeek(alloc *Alloc, name interface{}, pos scanner.Position) (ops []vm.InsOp, al arrayLoader, reg int) {
+	ops,al,reg = compileArrayLoader(alloc,name,false,pos)
+	if reg>=0 { al = avpeek(reg) }
+	return
+}
+func compileHashPeek(alloc *Alloc, name interface{}, pos scanner.Position) (ops []vm.InsOp, al hashLoader, reg int) {
+	ops,al,reg = compileHashLoader(alloc,name,false,pos)
+	if reg>=0 { al = hvpeek(reg) }
+	return
+}
+
+/*
+Compiles an expression yielding a reference, that is about to be written through.
+If it is a variable or an element holding undef, a new array or hash is stored into it.
+*/
+func compileVivify(alloc *Alloc, name interface{}, hash bool) (ops []vm.InsOp, reg int) {
+	o1,sl,regs := scUpdate(alloc,name,true)
+	if sl==nil { return ScCompile(alloc,name,ScAny) }
+	reg = alloc.GetScTarget(ScAny)
+	if hash {
+		ops = append(o1,vivify_hash(sl,reg))
+	} else {
+		ops = append(o1,vivify_array(sl,reg))
+	}
+	for _,oreg := range regs { alloc.PutScTarget(ScDiscard,oreg) }
+	return
+}
+
 func scTarget(alloc *Alloc, targ, src interface{}, sth ScTH) (ops []vm.InsOp,reg int) {
 	switch t := targ.(type) {
 	case *astparser.EScalar:
@@ -253,7 +481,7 @@ func scTarget(alloc *Alloc, targ, src interface{}, sth ScTH) (ops []vm.InsOp,reg
 				return
 			}
 			ops,reg = ScCompile(alloc,src,sth.DeferDiscard())
-			ops = append(ops,store_global(str,reg))
+			ops = append(ops,store_global(alloc.GetScGlobal(str,t.Pos),reg))
 			alloc.PutScTarget(sth,reg)
 		} else {
 			o1,r1 := ScCompile(alloc,t.Name,ScAny)
@@ -264,8 +492,16 @@ func scTarget(alloc *Alloc, targ, src interface{}, sth ScTH) (ops []vm.InsOp,reg
 			alloc.PutScTarget(ScDiscard,r1)
 			alloc.PutScTarget(sth,reg)
 		}
+	case *astparser.EMy:
+		// The initializer is compiled first, as in "my $x = $x;" it refers to the outer $x.
+		o1,r1 := ScCompile(alloc,src,ScAny)
+		alloc.MyDefine(t.Name)
+		var o2 []vm.InsOp
+		o2,reg = scTarget(alloc,&astparser.EScalar{t.Name[1:],t.Pos},scalarReg(r1),sth)
+		ops = append(o1,o2...)
+		alloc.PutScTarget(ScDiscard,r1)
 	case *astparser.EHashScalar:
-		o1,al,r1 := compileHashLoader(alloc,t.Name,true)
+		o1,al,r1 := compileHashLoader(alloc,t.Name,true,t.Pos)
 		o2,r2 := ScCompile(alloc,t.Index,ScAny)
 		o1 = append(o1,o2...)
 		ops,reg = ScCompile(alloc,src,sth.DeferDiscard())
@@ -275,7 +511,7 @@ func scTarget(alloc *Alloc, targ, src interface{}, sth ScTH) (ops []vm.InsOp,reg
 		alloc.PutScTarget(ScDiscard,r2)
 		alloc.PutScTarget(sth,reg)
 	case *astparser.EArrayScalar:
-		o1,al,r1 := compileArrayLoader(alloc,t.Name,true)
+		o1,al,r1 := compileArrayLoader(alloc,t.Name,true,t.Pos)
 		o2,r2 := ScCompile(alloc,t.Index,ScAny)
 		o1 = append(o1,o2...)
 		ops,reg = ScCompile(alloc,src,sth.DeferDiscard())
@@ -284,6 +520,13 @@ func scTarget(alloc *Alloc, targ, src interface{}, sth ScTH) (ops []vm.InsOp,reg
 		alloc.PutScTarget(ScDiscard,r1)
 		alloc.PutScTarget(ScDiscard,r2)
 		alloc.PutScTarget(sth,reg)
+	case *astparser.EPos:
+		o1,sl,regs := scUpdate(alloc,t.Var,false)
+		ops,reg = ScCompile(alloc,src,sth.DeferDiscard())
+		ops = append(o1,ops...)
+		ops = append(ops,pos_set(sl,reg))
+		for _,oreg := range regs { alloc.PutScTarget(ScDiscard,oreg) }
+		alloc.PutScTarget(sth,reg)
 	default:
 		pos,ok := astparser.Position(targ)
 		if ok {
@@ -303,7 +546,7 @@ func scUpdate(alloc *Alloc, ast interface{}, try bool) (ops []vm.InsOp, sl slotL
 			if reg,ok = alloc.GetScDefined(str); ok {
 				sl = slot_local(reg)
 			} else {
-				sl = slot_global(str)
+				sl = slot_global(alloc.GetScGlobal(str,t.Pos))
 			}
 		} else {
 			ops,reg = ScCompile(alloc,t.Name,ScAny)
@@ -313,7 +556,7 @@ func scUpdate(alloc *Alloc, ast interface{}, try bool) (ops []vm.InsOp, sl slotL
 	case *astparser.EHashScalar:
 		var al hashLoader
 		var r1 int
-		ops,al,r1 = compileHashLoader(alloc,t.Name,false)
+		ops,al,r1 = compileHashLoader(alloc,t.Name,true,t.Pos)
 		o2,r2 := ScCompile(alloc,t.Index,ScAny)
 		ops = append(ops,o2...)
 		sl = slot_hash(al,r2)
@@ -321,7 +564,7 @@ func scUpdate(alloc *Alloc, ast interface{}, try bool) (ops []vm.InsOp, sl slotL
 	case *astparser.EArrayScalar:
 		var al arrayLoader
 		var r1 int
-		ops,al,r1 = compileArrayLoader(alloc,t.Name,false)
+		ops,al,r1 = compileArrayLoader(alloc,t.Name,true,t.Pos)
 		o2,r2 := ScCompile(alloc,t.Index,ScAny)
 		ops = append(ops,o2...)
 		sl = slot_array(al,r2)
@@ -338,15 +581,60 @@ func scUpdate(alloc *Alloc, ast interface{}, try bool) (ops []vm.InsOp, sl slotL
 	
 	return
 }
-func allocNumbers(alloc *Alloc, rx *regexp.Regexp) (regs []int) {
-	n := rx.NumSubexp()+1
-	regs = make([]int,n)
-	for i := 0; i<n; i++ {
-		S := fmt.Sprint(i)
-		alloc.define(vm.RSM_Scalar,S,"")
-		regs[i],_ = alloc.GetScDefined(S)
+/*
+Like scUpdate, but for reading: Elements (and the containers on the way to them) aren't
+created, if they don't exist. The slot is nil at runtime then.
+*/
+func scPeek(alloc *Alloc, ast interface{}, try bool) (ops []vm.InsOp, sl slotLoader,regs []int) {
+	switch t := ast.(type) {
+	case *astparser.EHashScalar:
+		o1,al,r1 := compileHashPeek(alloc,t.Name,t.Pos)
+		o2,r2 := ScCompile(alloc,t.Index,ScAny)
+		return append(o1,o2...),slot_hash_peek(al,r2),[]int{r1,r2}
+	case *astparser.EArrayScalar:
+		o1,al,r1 := compileArrayPeek(alloc,t.Name,t.Pos)
+		o2,r2 := ScCompile(alloc,t.Index,ScAny)
+		return append(o1,o2...),slot_array_peek(al,r2),[]int{r1,r2}
 	}
-	return
+	return scUpdate(alloc,ast,try)
+}
+/*
+Compiles the operand of m//g. If it is a variable (or an element), its slot is returned,
+that holds pos(). Otherwise the value is in r1.
+*/
+func matchOperand(alloc *Alloc, ast interface{}) (ops []vm.InsOp, sl slotLoader, regs []int, r1 int) {
+	ops,sl,regs = scPeek(alloc,ast,true)
+	if sl!=nil { return ops,sl,regs,-1 }
+	ops,r1 = ScCompile(alloc,ast,ScAny)
+	return ops,nil,[]int{r1},r1
+}
+
+/*
+Compiles the match variables $&, $1..., $` and $' (the latter two are rewritten to
+${^PREMATCH} and ${^POSTMATCH} by the lexer). $0 is an alias of $&.
+*/
+func matchVariable(name string, reg int) vm.InsOp {
+	switch name {
+	case "&","^MATCH": return match_group(0,reg)
+	case "^PREMATCH": return match_prematch(reg)
+	case "^POSTMATCH": return match_postmatch(reg)
+	}
+	n,err := strconv.Atoi(name)
+	if err!=nil || n<0 || name[0]=='+' { return nil }
+	return match_group(n,reg)
+}
+
+/*
+Compiles a pattern literal again with the engine selected by "use re qw(engine);".
+Patterns, that use the backtracking engine already (by the b modifier or because RE2
+can't compile them), are kept.
+*/
+func (a *Alloc) regex(rx values.Regex, pos scanner.Position) values.Regex {
+	if a.rxEngine=="" { return rx }
+	if _,ok := rx.(*backtrack.Regexp); ok { return rx }
+	rx2,err := values.CompileRegexWith(a.rxEngine,rx.String())
+	if err!=nil { panic(fmt.Errorf("%v : Invalid regex: %v",pos,err)) }
+	return rx2
 }
 
 func ScCompile(alloc *Alloc, ast interface{}, sth ScTH) (ops []vm.InsOp,reg int) {
@@ -357,8 +645,10 @@ func ScCompile(alloc *Alloc, ast interface{}, sth ScTH) (ops []vm.InsOp,reg int)
 		ops = append(ops,scratch_shift_scalar(int(t),reg))
 		alloc.PutScTarget(sth,reg)
 	case *astparser.ELiteral:
+		lit := t.Scalar
+		if t.Bytes && !alloc.utf8 { lit = values.ScBuffer(lit.String()) } // source bytes, as in Perl
 		reg = alloc.GetScTarget(sth)
-		ops = append(ops,literal(t.Scalar,reg))
+		ops = append(ops,literal(lit,reg))
 		alloc.PutScTarget(sth,reg)
 	case string:
 		reg = alloc.GetScTarget(sth)
@@ -372,7 +662,12 @@ func ScCompile(alloc *Alloc, ast interface{}, sth ScTH) (ops []vm.InsOp,reg int)
 		if str,ok := t.Name.(string); ok {
 			if reg,ok = alloc.GetScDefined(str); ok { return }
 			reg = alloc.GetScTarget(sth)
-			ops = append(ops,load_global(str,reg))
+			if op := matchVariable(str,reg); op!=nil {
+				ops = append(ops,op)
+				alloc.PutScTarget(sth,reg)
+				return
+			}
+			ops = append(ops,load_global(alloc.GetScGlobal(str,t.Pos),reg))
 			alloc.PutScTarget(sth,reg)
 		} else {
 			o1,r1 := ScCompile(alloc,t.Name,ScAny)
@@ -385,7 +680,7 @@ func ScCompile(alloc *Alloc, ast interface{}, sth ScTH) (ops []vm.InsOp,reg int)
 	case *astparser.EHashScalar:
 		var al hashLoader
 		var r1 int
-		ops,al,r1 = compileHashLoader(alloc,t.Name,false)
+		ops,al,r1 = compileHashPeek(alloc,t.Name,t.Pos)
 		o2,r2 := ScCompile(alloc,t.Index,ScAny)
 		ops = append(ops,o2...)
 		reg = alloc.GetScTarget(sth)
@@ -395,7 +690,7 @@ func ScCompile(alloc *Alloc, ast interface{}, sth ScTH) (ops []vm.InsOp,reg int)
 	case *astparser.EArrayScalar:
 		var al arrayLoader
 		var r1 int
-		ops,al,r1 = compileArrayLoader(alloc,t.Name,false)
+		ops,al,r1 = compileArrayPeek(alloc,t.Name,t.Pos)
 		o2,r2 := ScCompile(alloc,t.Index,ScAny)
 		ops = append(ops,o2...)
 		reg = alloc.GetScTarget(sth)
@@ -423,26 +718,86 @@ func ScCompile(alloc *Alloc, ast interface{}, sth ScTH) (ops []vm.InsOp,reg int)
 		alloc.PutScTarget(ScDiscard,r2)
 		alloc.PutScTarget(sth,reg)
 	case *astparser.EMatchGlobal:
-		panic(fmt.Errorf("%v Unsupported: %v",t.Pos,ast))
+		o1,sl,regs,r1 := matchOperand(alloc,t.A)
+		reg = alloc.GetScTarget(sth)
+		ops = append(o1,regex_match_iter(alloc.regex(t.Rx,t.Pos),sl,r1,reg))
+		for _,oreg := range regs { alloc.PutScTarget(ScDiscard,oreg) }
+		alloc.PutScTarget(sth,reg)
+	case *astparser.EPos:
+		o1,sl,regs := scPeek(alloc,t.Var,false)
+		reg = alloc.GetScTarget(sth)
+		ops = append(o1,pos_get(sl,reg))
+		for _,oreg := range regs { alloc.PutScTarget(ScDiscard,oreg) }
+		alloc.PutScTarget(sth,reg)
 	case *astparser.EMatch:
 		o1,r1 := ScCompile(alloc,t.A,ScAny)
-		regs := allocNumbers(alloc,t.Rx)
-		reg = alloc.GetScTarget(sth)
 		ops = o1
-		ops = append(ops,regex_match(t.Rx,r1,reg,regs))
+		if t.Rx==nil {
+			o2,r2 := ScCompile(alloc,t.Pat,ScAny)
+			reg = alloc.GetScTarget(sth)
+			ops = append(ops,o2...)
+			ops = append(ops,regex_match_dyn(alloc.rxEngine,r2,r1,reg))
+			alloc.PutScTarget(ScDiscard,r2)
+		} else {
+			reg = alloc.GetScTarget(sth)
+			ops = append(ops,regex_match(alloc.regex(t.Rx,t.Pos),r1,reg))
+		}
 		alloc.PutScTarget(ScDiscard,r1)
 		alloc.PutScTarget(sth,reg)
+	case *astparser.ERegex:
+		reg = alloc.GetScTarget(sth)
+		ops = append(ops,regex_ref(alloc.regex(t.Rx,t.Pos),reg))
+		alloc.PutScTarget(sth,reg)
 	case *astparser.EReplace:
-		o1,r1 := ScCompile(alloc,t.A,ScAny)
+		global := strings.ContainsRune(t.Flags,'g')
+		var sl slotLoader
+		var regs []int
+		r1 := -1
+		if strings.ContainsRune(t.Flags,'r') {
+			ops,r1 = ScCompile(alloc,t.A,ScAny)
+			regs = []int{r1}
+		} else {
+			ops,sl,regs = scUpdate(alloc,t.A,false)
+		}
+		// The replacement is evaluated for every match, while the operand is still alive.
 		o2,r2 := ScCompile(alloc,t.B,ScAny)
 		reg = alloc.GetScTarget(sth)
-		ops = append(o1,o2...)
-		ops = append(ops,regex_replace(t.Rx,r1,r2,reg))
-		alloc.PutScTarget(ScDiscard,r1)
+		ops = append(ops,regex_replace(alloc.regex(t.Rx,t.Pos),global,sl,r1,o2,r2,reg))
+		for _,oreg := range regs { alloc.PutScTarget(ScDiscard,oreg) }
 		alloc.PutScTarget(ScDiscard,r2)
 		alloc.PutScTarget(sth,reg)
+	case *astparser.ETrans:
+		var sl slotLoader
+		var regs []int
+		r1 := -1
+		ret := strings.ContainsRune(t.Flags,'r')
+		if ret || t.Tr.Identity {
+			ops,r1 = ScCompile(alloc,t.A,ScAny)
+			regs = []int{r1}
+		} else {
+			ops,sl,regs = scUpdate(alloc,t.A,false)
+		}
+		reg = alloc.GetScTarget(sth)
+		ops = append(ops,transliterate(t.Tr,sl,r1,reg,ret))
+		for _,oreg := range regs { alloc.PutScTarget(ScDiscard,oreg) }
+		alloc.PutScTarget(sth,reg)
 	case *astparser.EScAssign:
 		return scTarget(alloc,t.A,t.B,sth)
+	case *astparser.EIncDec:
+		op := values.Inc
+		if t.Op=="--" { op = values.Dec }
+		o1,sl,regs := scUpdate(alloc,t.A,true)
+		if sl==nil {
+			kind := "increment"
+			if t.Op=="--" { kind = "decrement" }
+			if t.Post { kind = "post"+kind } else { kind = "pre"+kind }
+			panic(fmt.Errorf("%v : Can't modify non-lvalue in %s (%s)",t.Pos,kind,t.Op))
+		}
+		ops = o1
+		reg = alloc.GetScTarget(sth)
+		ops = append(ops,incdec(op,sl,t.Post,t.Op=="++",reg))
+		for _,oreg := range regs { alloc.PutScTarget(ScDiscard,oreg) }
+		alloc.PutScTarget(sth,reg)
 	case *astparser.EBinopAssign:
 		op,ok := binop_map[t.Op]
 		if !ok { panic(t.Pos.String()+" Binary Operation not supported: "+t.Op) }
@@ -455,6 +810,39 @@ func ScCompile(alloc *Alloc, ast interface{}, sth ScTH) (ops []vm.InsOp,reg int)
 		for _,oreg := range regs { alloc.PutScTarget(ScDiscard,oreg) }
 		alloc.PutScTarget(sth,reg)
 	case *astparser.EFromArray:
+		switch a := t.Array.(type) {
+		case *astparser.AConcat:
+			/* The comma operator: (1,2,3) yields 3. */
+			if len(a.Elems)==0 { return ScCompile(alloc,&astparser.ELiteral{values.Null(),a.Pos,false},sth) }
+			last := len(a.Elems)-1
+			for _,subex := range a.Elems[:last] {
+				var o1 []vm.InsOp
+				if astparser.IsArrayExpr(subex) {
+					o1,_ = ArCompile(alloc,subex,ScDiscard)
+				} else {
+					o1,_ = ScCompile(alloc,subex,ScDiscard)
+				}
+				ops = append(ops,o1...)
+			}
+			o2,r2 := ScCompile(alloc,a.Elems[last],sth)
+			return append(ops,o2...),r2
+		case *astparser.AExIfElse:
+			return ScCompile(alloc,&astparser.EExIfElse{a.Cond,a.Then,a.Else,a.Pos},sth)
+		case *astparser.AArray:
+			o1,al,r1 := compileArrayLoader(alloc,a.Name,false,a.Pos)
+			reg = alloc.GetScTarget(sth)
+			ops = append(o1,length_array(al,reg))
+			alloc.PutScTarget(ScDiscard,r1)
+			alloc.PutScTarget(sth,reg)
+			return
+		case *astparser.AHash:
+			o1,hl,r1 := compileHashLoader(alloc,a.Name,false,a.Pos)
+			reg = alloc.GetScTarget(sth)
+			ops = append(o1,length_hash(hl,reg))
+			alloc.PutScTarget(ScDiscard,r1)
+			alloc.PutScTarget(sth,reg)
+			return
+		}
 		var al arrayLoader
 		var ar int = -1
 		{
@@ -520,12 +908,51 @@ func ScCompile(alloc *Alloc, ast interface{}, sth ScTH) (ops []vm.InsOp,reg int)
 			ops = append(ops,o3...)
 		}
 	case *astparser.ESubCall,*astparser.EObjCall,*astparser.EModCall:
-		ops = callCompile(alloc,ast,false)
+		ctx := vm.CTX_Scalar
+		if sth==ScDiscard { ctx = vm.CTX_Void }
+		ops = callCompile(alloc,ast,false,ctx)
 		reg = alloc.GetScTarget(sth)
 		ops = append(ops,load_scalar_args(reg))
 		alloc.PutScTarget(sth,reg)
+	case *astparser.EMy:
+		ops = myCompile(alloc,t.Name)
+		reg,_ = alloc.GetScDefined(t.Name[1:])
+		if sth>=0 {
+			ops = append(ops,scalar_move(reg,int(sth)))
+			reg = int(sth)
+		}
+	case scalarReg:
+		reg = int(t)
+	case *astparser.EWantArray:
+		reg = alloc.GetScTarget(sth)
+		ops = append(ops,wantarray(reg))
+		alloc.PutScTarget(sth,reg)
+	case *astparser.EExists:
+		switch e := t.Expr.(type) {
+		case *astparser.EHashScalar:
+			o1,hl,r1 := compileHashPeek(alloc,e.Name,e.Pos)
+			o2,r2 := ScCompile(alloc,e.Index,ScAny)
+			reg = alloc.GetScTarget(sth)
+			ops = append(append(o1,o2...),exists_hash(hl,r2,reg))
+			alloc.PutScTarget(ScDiscard,r1)
+			alloc.PutScTarget(ScDiscard,r2)
+		case *astparser.EArrayScalar:
+			o1,al,r1 := compileArrayPeek(alloc,e.Name,e.Pos)
+			o2,r2 := ScCompile(alloc,e.Index,ScAny)
+			reg = alloc.GetScTarget(sth)
+			ops = append(append(o1,o2...),exists_array(al,r2,reg))
+			alloc.PutScTarget(ScDiscard,r1)
+			alloc.PutScTarget(ScDiscard,r2)
+		}
+		alloc.PutScTarget(sth,reg)
+	case *astparser.ELastIndex:
+		o1,al,r1 := compileArrayPeek(alloc,t.Name,t.Pos)
+		reg = alloc.GetScTarget(sth)
+		ops = append(o1,last_index(al,reg))
+		alloc.PutScTarget(ScDiscard,r1)
+		alloc.PutScTarget(sth,reg)
 	case *astparser.EGoFunction:
-		ops = callCompile(alloc,t.Call,true)
+		ops = callCompile(alloc,t.Call,true,vm.CTX_Void)
 		reg = alloc.GetScTarget(sth)
 		ops = append(ops,literal(values.Null(),reg))
 		alloc.PutScTarget(sth,reg)
@@ -559,10 +986,10 @@ func arAssign(alloc *Alloc, targ, src interface{}, sth ScTH) (ops []vm.InsOp, re
 				return
 			}
 			ops,reg = ArCompile(alloc,src,sth.DeferDiscard())
-			ops = append(ops,store_array_global(str,reg))
+			ops = append(ops,store_array_global(alloc.GetArGlobal(str,t.Pos),reg))
 			alloc.PutArTarget(sth,reg)
 		} else {
-			o1,r1 := ScCompile(alloc,t.Name,ScAny)
+			o1,r1 := compileVivify(alloc,t.Name,false)
 			ops,reg = ArCompile(alloc,src,sth.DeferDiscard())
 			ops = append(o1,ops...)
 			ops = append(ops,store_array_unref(r1,reg))
@@ -570,9 +997,9 @@ func arAssign(alloc *Alloc, targ, src interface{}, sth ScTH) (ops []vm.InsOp, re
 			alloc.PutArTarget(sth,reg)
 		}
 	case *astparser.AHash:
-		o1,al,r1 := compileHashLoader(alloc,t.Name,true)
+		o1,al,r1 := compileHashLoader(alloc,t.Name,true,t.Pos)
 		if ts,ok := src.(*astparser.AHash); ok {
-			o2,l2,r2 := compileHashLoader(alloc,ts.Name,false)
+			o2,l2,r2 := compileHashLoader(alloc,ts.Name,false,ts.Pos)
 			ops = append(o2,o1...)
 			ops = append(ops,hash_transfer(l2,al))
 			if sth==ScDiscard {
@@ -592,20 +1019,14 @@ func arAssign(alloc *Alloc, targ, src interface{}, sth ScTH) (ops []vm.InsOp, re
 			alloc.PutArTarget(sth,reg)
 		}
 	case *astparser.AConcat:
-		ops,reg := ArCompile(alloc,src,sth.DeferDiscard())
-		treg := alloc.GetArDangling()
-		ops = append(ops,scratch_init(treg,reg))
-		for _,subex := range t.Elems {
-			if !astparser.IsArrayExpr(subex) {
-				o1,_ := scTarget(alloc,subex,shiftFrom(treg),ScDiscard)
-				ops = append(ops,o1...)
-			} else {
-				o1,_ := arAssign(alloc,subex,shiftFrom(treg),ScDiscard)
-				ops = append(ops,o1...)
-			}
-		}
-		ops = append(ops,scratch_null(treg))
-		alloc.PutArTarget(ScDiscard,treg)
+		ops,reg = ArCompile(alloc,src,sth.DeferDiscard())
+		ops = append(ops,arDistribute(alloc,t.Elems,reg)...)
+		alloc.PutArTarget(sth,reg)
+	case *astparser.AMy:
+		// The initializer is compiled first, as in "my @x = @x;" it refers to the outer @x.
+		ops,reg = ArCompile(alloc,src,sth.DeferDiscard())
+		for _,v := range t.Vars { ops = append(ops,myCompile(alloc,v.(string))...) }
+		ops = append(ops,arDistribute(alloc,myTargets(t),reg)...)
 		alloc.PutArTarget(sth,reg)
 	default:
 		pos,ok := astparser.Position(targ)
@@ -617,6 +1038,39 @@ func arAssign(alloc *Alloc, targ, src interface{}, sth ScTH) (ops []vm.InsOp, re
 	}
 	return
 }
+/*
+Assigns the elements of the array register reg to the targets, as in ($a,$b,@c) = ...;
+*/
+func arDistribute(alloc *Alloc, targets []interface{}, reg int) (ops []vm.InsOp) {
+	treg := alloc.GetArDangling()
+	ops = append(ops,scratch_init(treg,reg))
+	for _,subex := range targets {
+		if !astparser.IsArrayExpr(subex) {
+			o1,_ := scTarget(alloc,subex,shiftFrom(treg),ScDiscard)
+			ops = append(ops,o1...)
+		} else {
+			o1,_ := arAssign(alloc,subex,shiftFrom(treg),ScDiscard)
+			ops = append(ops,o1...)
+		}
+	}
+	ops = append(ops,scratch_null(treg))
+	alloc.PutArTarget(ScDiscard,treg)
+	return
+}
+
+// The variables of my (...) as assignable expressions.
+func myTargets(t *astparser.AMy) (targets []interface{}) {
+	for _,v := range t.Vars {
+		s := v.(string)
+		switch s[0] {
+		case '$': targets = append(targets,&astparser.EScalar{s[1:],t.Pos})
+		case '@': targets = append(targets,&astparser.AArray{s[1:],t.Pos})
+		case '%': targets = append(targets,&astparser.AHash{s[1:],t.Pos})
+		}
+	}
+	return
+}
+
 func arConcatElem(alloc *Alloc, ast interface{}, treg int) (ops []vm.InsOp) {
 	var reg int
 	if !astparser.IsArrayExpr(ast) {
@@ -634,7 +1088,7 @@ func arConcatElem(alloc *Alloc, ast interface{}, treg int) (ops []vm.InsOp) {
 func arForArrayLoader(alloc *Alloc, ast interface{}, w bool) (ops []vm.InsOp, al arrayLoader, reg int) {
 	switch t := ast.(type) {
 	case *astparser.AArray:
-		return compileArrayLoader(alloc,t.Name,w)
+		return compileArrayLoader(alloc,t.Name,w,t.Pos)
 	default:
 		ops,reg = ArCompile(alloc,ast,ScAny)
 		al = avlocal(reg)
@@ -657,8 +1111,10 @@ func ArCompile(alloc *Alloc, ast interface{}, sth ScTH) (ops []vm.InsOp, reg int
 			reg = alloc.GetArTarget(sth)
 			if str=="_" {
 				ops = append(ops,load_array_args(reg))
+			} else if str=="-" || str=="+" {
+				ops = append(ops,load_array_from(avmatch(str=="+"),reg))
 			} else {
-				ops = append(ops,load_array_global(str,reg))
+				ops = append(ops,load_array_global(alloc.GetArGlobal(str,t.Pos),reg))
 			}
 			alloc.PutArTarget(sth,reg)
 		} else {
@@ -670,13 +1126,33 @@ func ArCompile(alloc *Alloc, ast interface{}, sth ScTH) (ops []vm.InsOp, reg int
 			alloc.PutArTarget(sth,reg)
 		}
 	case *astparser.AHash:
-		o1,al,r1 := compileHashLoader(alloc,t.Name,false)
+		o1,al,r1 := compileHashLoader(alloc,t.Name,false,t.Pos)
 		reg = alloc.GetArTarget(sth)
 		ops = append(o1,hash_to_array(al,reg))
 		alloc.PutScTarget(ScDiscard,r1)
 		alloc.PutArTarget(sth,reg)
+	case *astparser.EMatchGlobal:
+		// In void context, m//g advances pos(), as in scalar context.
+		if sth==ScDiscard {
+			ops,_ = ScCompile(alloc,t,ScDiscard)
+			return ops,-1
+		}
+		o1,sl,regs,r1 := matchOperand(alloc,t.A)
+		reg = alloc.GetArTarget(sth)
+		ops = append(o1,regex_match_all(alloc.regex(t.Rx,t.Pos),sl,r1,reg))
+		for _,oreg := range regs { alloc.PutScTarget(ScDiscard,oreg) }
+		alloc.PutArTarget(sth,reg)
 	case *astparser.AArAssign:
 		ops,reg = arAssign(alloc,t.A,t.B,sth)
+	case *astparser.AMy:
+		for _,v := range t.Vars { ops = append(ops,myCompile(alloc,v.(string))...) }
+		if sth==ScDiscard {
+			reg = -1
+			return
+		}
+		var o1 []vm.InsOp
+		o1,reg = ArCompile(alloc,&astparser.AConcat{myTargets(t),t.Pos},sth)
+		ops = append(ops,o1...)
 	case *astparser.AConcat:
 		reg = alloc.GetArTarget(sth)
 		ops = append(ops,scratch_clear(reg))
@@ -719,12 +1195,14 @@ func ArCompile(alloc *Alloc, ast interface{}, sth ScTH) (ops []vm.InsOp, reg int
 			ops = append(ops,o3...)
 		}
 	case *astparser.ESubCall,*astparser.EObjCall,*astparser.EModCall:
-		ops = callCompile(alloc,ast,false)
+		ctx := vm.CTX_List
+		if sth==ScDiscard { ctx = vm.CTX_Void }
+		ops = callCompile(alloc,ast,false,ctx)
 		reg = alloc.GetArTarget(sth)
 		ops = append(ops,load_array_args(reg))
 		alloc.PutArTarget(sth,reg)
 	case *astparser.EGoFunction:
-		ops = callCompile(alloc,t.Call,true)
+		ops = callCompile(alloc,t.Call,true,vm.CTX_Void)
 		reg = alloc.GetArTarget(sth)
 		ops = append(ops,scratch_clear(reg))
 		alloc.PutArTarget(sth,reg)
@@ -739,18 +1217,30 @@ func ArCompile(alloc *Alloc, ast interface{}, sth ScTH) (ops []vm.InsOp, reg int
 	return
 }
 
-func callCompile(alloc *Alloc, ast interface{}, dogo bool) (ops []vm.InsOp) {
+func callCompile(alloc *Alloc, ast interface{}, dogo bool, ctx int) (ops []vm.InsOp) {
 	switch t := ast.(type) {
 	case *astparser.ESubCall:
+		if f,ok := builtins[t.Name]; ok && !dogo {
+			reg := alloc.GetArTarget(ScAny)
+			ops = append(ops,scratch_clear(reg))
+			for _,subex := range t.Args {
+				ops = append(ops,arConcatElem(alloc,subex,reg)...)
+			}
+			ops = append(ops,store_array_args(reg),builtincall(f,ctx))
+			alloc.PutArTarget(ScDiscard,reg)
+			return
+		}
+		name := alloc.Qualify(t.Name)
+		if !strings.Contains(t.Name,"::") { alloc.checkSub(name,t.Pos) }
 		reg := alloc.GetArTarget(ScAny)
 		ops = append(ops,scratch_clear(reg))
 		for _,subex := range t.Args {
 			ops = append(ops,arConcatElem(alloc,subex,reg)...)
 		}
 		if dogo {
-			ops = append(ops,store_array_args(reg),subcallgo(t.Name))
+			ops = append(ops,store_array_args(reg),subcallgo(name,t.Pos,ctx))
 		} else {
-			ops = append(ops,store_array_args(reg),subcall(t.Name))
+			ops = append(ops,store_array_args(reg),subcall(name,t.Pos,ctx))
 		}
 		alloc.PutArTarget(ScDiscard,reg)
 	case *astparser.EObjCall:
@@ -762,9 +1252,9 @@ func callCompile(alloc *Alloc, ast interface{}, dogo bool) (ops []vm.InsOp) {
 			ops = append(ops,arConcatElem(alloc,subex,reg)...)
 		}
 		if dogo {
-			ops = append(ops,store_array_args(reg),modcallgo(r1,t.Name))
+			ops = append(ops,store_array_args(reg),modcallgo(r1,t.Name,t.Pos,ctx))
 		} else {
-			ops = append(ops,store_array_args(reg),modcall(r1,t.Name))
+			ops = append(ops,store_array_args(reg),modcall(r1,t.Name,t.Pos,ctx))
 		}
 		alloc.PutScTarget(ScDiscard,r1)
 		alloc.PutArTarget(ScDiscard,reg)
@@ -777,9 +1267,9 @@ func callCompile(alloc *Alloc, ast interface{}, dogo bool) (ops []vm.InsOp) {
 			ops = append(ops,arConcatElem(alloc,subex,reg)...)
 		}
 		if dogo {
-			ops = append(ops,store_array_args(reg),modcallgo(r1,t.Name))
+			ops = append(ops,store_array_args(reg),modcallgo(r1,t.Name,t.Pos,ctx))
 		} else {
-			ops = append(ops,store_array_args(reg),modcall(r1,t.Name))
+			ops = append(ops,store_array_args(reg),modcall(r1,t.Name,t.Pos,ctx))
 		}
 		alloc.PutScTarget(ScDiscard,r1)
 		alloc.PutArTarget(ScDiscard,reg)
@@ -787,50 +1277,134 @@ func callCompile(alloc *Alloc, ast interface{}, dogo bool) (ops []vm.InsOp) {
 	return
 }
 
+/*
+Declares a lexical variable (my $x). The variable is reset each time, the declaration
+is executed, so that every iteration of a loop gets a fresh one.
+*/
+func myCompile(alloc *Alloc, s string) (ops []vm.InsOp) {
+	alloc.MyDefine(s)
+	switch s[0] {
+	case '$':
+		reg,_ := alloc.GetScDefined(s[1:])
+		ops = append(ops,literal(values.Null(),reg))
+	case '@':
+		reg,_ := alloc.GetArDefined(s[1:])
+		ops = append(ops,scratch_null(reg))
+	case '%':
+		reg,_ := alloc.GetHsDefined(s[1:])
+		ops = append(ops,hash_null(reg))
+	}
+	return
+}
+
+// LABEL: while (cond) {body}
+func whileCompile(alloc *Alloc, t *astparser.SCond, label string) (ops []vm.InsOp) {
+	alloc.Enter()
+	defer alloc.Leave()
+	o1,r1 := ScCompile(alloc,t.Cond,ScAny)
+	alloc.PutScTarget(ScDiscard,r1)
+	alloc.loops = append(alloc.loops,label)
+	o2,lt := stmtCompile(alloc,t.Body)
+	alloc.loops = alloc.loops[:len(alloc.loops)-1]
+	alloc.block(o2,&lt)
+	return append(ops,loop(label,o1,r1,o2))
+}
+
+// LABEL: for $var (src) {body}
+func forCompile(alloc *Alloc, t *astparser.SFor, label string) (ops []vm.InsOp) {
+	alloc.Enter()
+	defer alloc.Leave()
+	o1,l1,r1 := arForArrayLoader(alloc,t.Src,false)
+	ops = o1
+	if t.My {
+		alloc.SetScDefine(t.Var)
+	} else {
+		alloc.SetScDefineImplicit(t.Var)
+	}
+	tr,_ := alloc.GetScDefined(t.Var)
+	alloc.loops = append(alloc.loops,label)
+	o2,lt := stmtCompile(alloc,t.Body)
+	alloc.loops = alloc.loops[:len(alloc.loops)-1]
+	alloc.block(o2,&lt)
+	ops = append(ops,loop_for(label,l1,tr,o2))
+	alloc.PutArTarget(ScDiscard,r1)
+	return
+}
+
 func StmtCompile(alloc *Alloc, ast interface{}) (ops []vm.InsOp) {
+	ops,_ = stmtCompile(alloc,ast)
+	return
+}
+
+/*
+Compiles a statement. lt maps the instructions to the positions of the statements, they
+have been compiled from.
+*/
+func stmtCompile(alloc *Alloc, ast interface{}) (ops []vm.InsOp, lt vm.LineTable) {
+	if pos,ok := astparser.Position(ast); ok { lt.Add(0,pos) }
 	switch t := ast.(type) {
 	case *astparser.SMyVars:
-		for _,s := range t.Vars { alloc.MyDefine(s.(string)) }
+		for _,s := range t.Vars { ops = append(ops,myCompile(alloc,s.(string))...) }
+	case *astparser.SOurVars:
+		for _,s := range t.Vars {
+			ops = append(ops,declare_global(alloc.OurDefine(s.(string))))
+		}
+	case *astparser.SSubDecl:
+		if len(alloc.ours)==0 { break }
+		if alloc.subOurs==nil { alloc.subOurs = make(map[*astparser.MDSub]map[string]string) }
+		ours := make(map[string]string,len(alloc.ours))
+		for k,v := range alloc.ours { ours[k] = v }
+		alloc.subOurs[t.Sub] = ours
+	case *astparser.SPackage:
+		old := alloc.Package
+		alloc.Package = t.Name
+		ops,lt = stmtCompile(alloc,t.Body)
+		alloc.Package = old
 	case *astparser.SExpr:
 		ops,_ = ScCompile(alloc,t.Expr,ScDiscard)
 	case *astparser.SArray:
 		ops,_ = ArCompile(alloc,t.Expr,ScDiscard)
 	case *astparser.SBlock:
+		alloc.Enter()
 		for _,s := range t.Stmts {
-			o := StmtCompile(alloc,s)
+			o,l := stmtCompile(alloc,s)
+			lt.Merge(&l,len(ops))
 			ops = append(ops,o...)
 		}
+		alloc.Leave()
 	case *astparser.SCond:
+		if t.Type=="while" { return whileCompile(alloc,t,""),lt }
+		alloc.Enter()
+		defer alloc.Leave()
 		o1,r1 := ScCompile(alloc,t.Cond,ScAny)
 		alloc.PutScTarget(ScDiscard,r1)
-		o2 := StmtCompile(alloc,t.Body)
+		o2,l2 := stmtCompile(alloc,t.Body)
 		switch t.Type {
 		case "if":
 			ops = append(o1,jump_unless(len(o2),r1))
-			ops = append(ops,o2...)
 		case "unless":
 			ops = append(o1,jump_if(len(o2),r1))
-			ops = append(ops,o2...)
-		case "while":
-			slice := append(o1,jump_if(1,r1),last)
-			slice = append(slice,o2...)
-			ops = append(ops,loop(slice))
 		}
+		lt.Merge(&l2,len(ops))
+		ops = append(ops,o2...)
 		ops = append(ops,noop)
 	case *astparser.SIfElse:
+		alloc.Enter()
+		defer alloc.Leave()
 		o1,r1 := ScCompile(alloc,t.Cond,ScAny)
 		alloc.PutScTarget(ScDiscard,r1)
-		o2 := StmtCompile(alloc,t.Body)
-		o3 := StmtCompile(alloc,t.Else)
+		o2,l2 := stmtCompile(alloc,t.Body)
+		o3,l3 := stmtCompile(alloc,t.Else)
 		switch t.Type {
 		case "if":
 			ops = append(o1,jump_unless(len(o2)+1,r1))
-			ops = append(ops,o2...)
 		case "unless":
 			ops = append(o1,jump_if(len(o2)+1,r1))
-			ops = append(ops,o2...)
 		}
+		lt.Merge(&l2,len(ops))
+		ops = append(ops,o2...)
 		ops = append(ops,jump(len(o3)))
+		lt.Merge(&l3,len(ops))
 		ops = append(ops,o3...)
 	case *astparser.SPrint:
 		o1,r1 := ScCompile(alloc,t.Expr,ScAny)
@@ -838,23 +1412,56 @@ func StmtCompile(alloc *Alloc, ast interface{}) (ops []vm.InsOp) {
 		ops = append(o1,debug(r1)) // TODO: replace debug
 	case *astparser.SNoop: // Do nothing!
 	case *astparser.SFor:
-		o1,l1,r1 := arForArrayLoader(alloc,t.Src,false)
-		ops = o1
-		alloc.SetScDefineImplicit(t.Var)
-		tr,_ := alloc.GetScDefined(t.Var)
-		o2 := StmtCompile(alloc,t.Body)
-		ops = append(ops,loop_for(l1,tr,o2))
-		alloc.PutArTarget(ScDiscard,r1)
+		return forCompile(alloc,t,""),lt
+	case *astparser.SLabeled:
+		switch b := t.Body.(type) {
+		case *astparser.SFor:
+			return forCompile(alloc,b,t.Label),lt
+		case *astparser.SCond:
+			if b.Type=="while" { return whileCompile(alloc,b,t.Label),lt }
+		}
+		panic(fmt.Errorf("%v : Label %s does not name a loop",t.Pos,t.Label))
 	case *astparser.SEval:
 		alloc.SetScDefineImplicit("@")
 		xr,_ := alloc.GetScDefined("@")
-		o1 := StmtCompile(alloc,t.Body)
+		o1,l1 := stmtCompile(alloc,t.Body)
+		alloc.block(o1,&l1)
 		ops = append(ops,eval(xr,o1))
 	case *astparser.SLoopJump:
+		if t.Label!="" && !alloc.inLoop(t.Label) {
+			panic(fmt.Errorf("%v : Label not found for \"%s %s\"",t.Pos,t.Op,t.Label))
+		}
+		if t.Label=="" && len(alloc.loops)==0 {
+			panic(fmt.Errorf("%v : Can't \"%s\" outside a loop block",t.Pos,t.Op))
+		}
 		switch t.Op {
-		case "next": ops = append(ops,next)
-		case "last": ops = append(ops,last)
+		case "next": ops = append(ops,loop_jump(vm.TSF_Next,t.Label))
+		case "last": ops = append(ops,loop_jump(vm.TSF_Last,t.Label))
+		case "redo": ops = append(ops,loop_jump(vm.TSF_Redo,t.Label))
+		}
+	case *astparser.SReturn:
+		if t.Expr==nil {
+			ops = append(ops,return_empty)
+			break
 		}
+		/*
+		The expression is evaluated in the context of the call,
+		so both variants are compiled and the list context one is picked at runtime.
+		Each one gets a scope of its own, as both declare the variables of "return my $x = ...;".
+		*/
+		alloc.Enter()
+		o1,r1 := ScCompile(alloc,t.Expr,ScAny)
+		alloc.PutScTarget(ScDiscard,r1)
+		alloc.Leave()
+		alloc.Enter()
+		o2,r2 := ArCompile(alloc,t.Expr,ScAny)
+		alloc.PutArTarget(ScDiscard,r2)
+		alloc.Leave()
+		ops = append(ops,jump_if_list(len(o1)+1))
+		ops = append(ops,o1...)
+		ops = append(ops,return_scalar(r1))
+		ops = append(ops,o2...)
+		ops = append(ops,return_array(r2))
 	case *astparser.SRequireStatic:
 		ops = append(ops,require_module(t.Mod))
 	case *astparser.SRequireDynamic:
@@ -873,22 +1480,167 @@ func StmtCompile(alloc *Alloc, ast interface{}) (ops []vm.InsOp) {
 	return
 }
 
+/*
+Compiles the signature of a sub into assignments from @_ to its parameters.
+*/
+func SigCompile(alloc *Alloc, name string, sig []*astparser.MDParam) (ops []vm.InsOp) {
+	min,max := 0,len(sig)
+	defaults := false
+	for i,param := range sig {
+		switch {
+		case param.Name[0]!='$':
+			if i+1<len(sig) { panic(fmt.Errorf("%v : Slurpy parameter not last: %s",param.Pos,param.Name)) }
+			if param.Default!=nil { panic(fmt.Errorf("%v : Slurpy parameter may not have a default value: %s",param.Pos,param.Name)) }
+			max = -1
+		case param.Default!=nil:
+			defaults = true
+		default:
+			if min<i { panic(fmt.Errorf("%v : Mandatory parameter follows optional parameter: %s",param.Pos,param.Name)) }
+			min = i+1
+		}
+	}
+	ops = append(ops,sig_check(name,min,max))
+	
+	// Default values might call subs, which would overwrite @_
+	var al arrayLoader = avargs
+	areg := -1
+	if defaults {
+		areg = alloc.GetArTarget(ScAny)
+		ops = append(ops,copy_array_args(areg))
+		al = avlocal(areg)
+	}
+	for i,param := range sig {
+		alloc.MyDefine(param.Name)
+		n := param.Name[1:]
+		switch param.Name[0] {
+		case '$':
+			reg,_ := alloc.GetScDefined(n)
+			if param.Default==nil {
+				ops = append(ops,sig_scalar(al,i,reg))
+				continue
+			}
+			o1,r1 := ScCompile(alloc,param.Default,ScTH(reg))
+			if r1!=reg { o1 = append(o1,scalar_move(r1,reg)) }
+			ops = append(ops,sig_scalar_default(al,i,reg,len(o1)))
+			ops = append(ops,o1...)
+		case '@':
+			reg,_ := alloc.GetArDefined(n)
+			ops = append(ops,sig_array(al,i,reg))
+		case '%':
+			reg,_ := alloc.GetHsDefined(n)
+			ops = append(ops,sig_hash(name,al,i,reg))
+		}
+	}
+	alloc.PutArTarget(ScDiscard,areg)
+	return
+}
+
 func SubCompile(md *vm.Module, ast *astparser.MDSub) *vm.Procedure {
+	p,_ := subCompile(md,ast,&pragmas{})
+	return p
+}
+/* The pragmas of a module, that affect the compilation of its subs. */
+type pragmas struct{
+	strict *strictDecls // use strict;
+	rxEngine string // use re qw(engine);
+	utf8 bool // use utf8;
+	subOurs map[*astparser.MDSub]map[string]string // see Alloc.subOurs
+}
+
+func subCompile(md *vm.Module, ast *astparser.MDSub, pr *pragmas) (*vm.Procedure, map[*astparser.MDSub]map[string]string) {
 	alloc := new(Alloc)
-	code := StmtCompile(alloc,ast.Body)
+	alloc.Module = md.Name
+	alloc.ours = pr.subOurs[ast]
+	alloc.strict = pr.strict
+	alloc.rxEngine = pr.rxEngine
+	alloc.utf8 = pr.utf8
+	var code []vm.InsOp
+	var lines vm.LineTable
+	lines.Add(0,ast.Pos)
+	if ast.Sig!=nil { code = SigCompile(alloc,md.Name+"::"+ast.Name,ast.Sig) }
+	body,lt := stmtCompile(alloc,ast.Body)
+	lines.Merge(&lt,len(code))
+	code = append(code,body...)
 	
 	// If i have no return statement i want to have return ();
 	code = append(code,empty_args)
 	
-	return &vm.Procedure{md,alloc.RSM,code}
+	return &vm.Procedure{Parent: md, Mets: alloc.RSM, Instrs: code, Name: ast.Name, Lines: lines, Blocks: alloc.blocks},alloc.subOurs
+}
+
+func exportList(md *vm.Module, name string) (names []string) {
+	v,ok := md.Arrays.Load(name)
+	if !ok { return }
+	for _,sc := range *(v.(*values.AV)) {
+		names = append(names,strings.TrimPrefix(sc.String(),"&"))
+	}
+	return
+}
+
+/*
+Loads the module at compile time and imports the requested subs and variables into md.
+Without an import list, @EXPORT is imported. Every name must be listed in either
+@EXPORT or @EXPORT_OK.
+*/
+func UseCompile(cl *vm.ClassLoader, md *vm.Module, ast *astparser.MDUse) (imported []string) {
+	sm := cl.GetModule(ast.Mod).(*values.ScModule)
+	src,ok := vm.LoadModule(sm,vm.NewThreadState())
+	if !ok { panic(fmt.Errorf("%v : module not found: %s",ast.Pos,ast.Mod)) }
+	
+	export := exportList(src,"EXPORT")
+	allowed := make(map[string]bool)
+	for _,n := range export { allowed[n] = true }
+	for _,n := range exportList(src,"EXPORT_OK") { allowed[n] = true }
+	
+	names := ast.Imports
+	if names==nil { names = export }
+	for _,n := range names {
+		n = strings.TrimPrefix(n,"&")
+		if !allowed[n] { panic(fmt.Errorf("%v : %s is not exported by module %s",ast.Pos,n,ast.Mod)) }
+		if !md.Import(src,n) { panic(fmt.Errorf("%v : sub %s is not defined in module %s",ast.Pos,n,ast.Mod)) }
+		imported = append(imported,n)
+	}
+	return
 }
 
 func ModCompile(cl *vm.ClassLoader, name string, ast *astparser.Module) *vm.Module {
 	md := &vm.Module{Parent: cl, Name: name}
-	md.Main = SubCompile(md,ast.Main)
+	
+	// Every package declared in the file gets its own module, unless it exists already.
+	pkgs := map[string]*vm.Module{"": md, name: md}
+	for _,pkg := range ast.Packages {
+		if _,ok := pkgs[pkg]; ok { continue }
+		if v,ok := cl.Modules.Load(pkg); ok { pkgs[pkg] = v.(*vm.Module); continue }
+		pm := &vm.Module{Parent: cl, Name: pkg}
+		pm.Main = &vm.Procedure{Parent: pm}
+		pkgs[pkg] = pm
+		md.Packages = append(md.Packages,pm)
+	}
+	
+	var strict *strictDecls
+	if ast.Strict {
+		strict = newStrictDecls()
+		for _,sub := range ast.Subs { strict.subs[pkgs[sub.Package].Name+"::"+sub.Name] = true }
+	}
+	
+	for _,use := range ast.Uses {
+		pm := pkgs[use.Package]
+		imported := UseCompile(cl,pm,use)
+		if strict!=nil { strict.imported(pm.Name,imported) }
+	}
+	
+	pr := &pragmas{strict: strict, utf8: ast.UTF8}
+	if use := ast.Regex; use!=nil {
+		if len(use.Imports)!=1 { panic(fmt.Errorf("%v : use re expects the name of a regex engine",use.Pos)) }
+		if !values.HasRegexEngine(use.Imports[0]) { panic(fmt.Errorf("%v : unknown regex engine: %s",use.Pos,use.Imports[0])) }
+		pr.rxEngine = use.Imports[0]
+	}
+	
+	md.Main,pr.subOurs = subCompile(md,ast.Main,pr)
 	for _,sub := range ast.Subs {
-		p := SubCompile(md,sub)
-		md.Procedures.Store(sub.Name,p)
+		pm := pkgs[sub.Package]
+		p,_ := subCompile(pm,sub,pr)
+		pm.Procedures.Store(sub.Name,p)
 	}
 	return md
 }
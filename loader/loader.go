@@ -33,7 +33,9 @@ import (
 	"fmt"
 )
 import (
+	"bytes"
 	"io"
+	"io/ioutil"
 	"os"
 	fpath "path/filepath"
 	"strings"
@@ -42,6 +44,8 @@ import (
 
 type GenericLoader struct{
 	Parser parser.Parser
+	
+	Strict bool // compile every module as if it had "use strict;"
 }
 func CreateGenericLoader() *GenericLoader {
 	gl := new(GenericLoader)
@@ -50,13 +54,16 @@ func CreateGenericLoader() *GenericLoader {
 	return gl
 }
 func (gl *GenericLoader) load(cl *vm.ClassLoader, r io.Reader, name, fn string) *vm.Module {
+	src,err := ioutil.ReadAll(r)
+	if err!=nil { panic(err) }
 	var bs scanlist.BaseScanner
-	bs.Init(r)
+	bs.Init(bytes.NewReader(astparser.RewriteLiterals(src)))
 	bs.Filename = fn
 	bs.Dict = astparser.Keywords
 	res := gl.Parser.Match("Module",bs.Next())
 	if !res.Ok() { panic(fmt.Sprint(res.Pos," : ",res.Data)) }
 	sm := res.Data.(*astparser.Module)
+	if gl.Strict { sm.Strict = true }
 	return comp.ModCompile(cl,name,sm)
 }
 func (gl *GenericLoader) LoadModuleFrom(paths []string, cl *vm.ClassLoader, name string) *vm.Module {
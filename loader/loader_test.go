@@ -0,0 +1,294 @@
+/*
+Copyright (c) 2021 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+
+package loader
+
+import (
+	"github.com/byte-mug/dream/backtrack"
+	"github.com/byte-mug/dream/values"
+	"github.com/byte-mug/dream/vm"
+	"fmt"
+	"io/ioutil"
+	"os"
+	fpath "path/filepath"
+	"strings"
+	"testing"
+)
+
+/*
+Runs src as the module T and returns what it printed. A panic (a compile error or a
+RuntimeError) is returned as err.
+*/
+func runModule(t *testing.T, src string) (out string, err interface{}) {
+	return runModules(t,map[string]string{"T":src})
+}
+
+// Like runModule, but with other modules next to T, that it can require.
+func runModules(t *testing.T, mods map[string]string) (out string, err interface{}) {
+	dir := t.TempDir()
+	for name,src := range mods {
+		if e := ioutil.WriteFile(fpath.Join(dir,name+".dm"),[]byte(src),0644); e!=nil { t.Fatal(e) }
+	}
+	cl := new(vm.ClassLoader)
+	cl.Spi = &SpecificLoader{GL: CreateGenericLoader(), Paths: []string{dir}}
+	return capture(t,func() {
+		if _,ok := vm.LoadModule(cl.GetModule("T").(*values.ScModule),vm.NewThreadState()); !ok { t.Fatal("module T not found") }
+	})
+}
+
+// Runs f with os.Stdout redirected.
+func capture(t *testing.T, f func()) (out string, err interface{}) {
+	r,w,e := os.Pipe()
+	if e!=nil { t.Fatal(e) }
+	stdout := os.Stdout
+	os.Stdout = w
+	done := make(chan string)
+	go func() {
+		b,_ := ioutil.ReadAll(r)
+		done <- string(b)
+	}()
+	func() {
+		defer func() { err = recover() }()
+		f()
+	}()
+	os.Stdout = stdout
+	w.Close()
+	return <-done,err
+}
+
+type scriptCase struct{
+	name string
+	src string
+	out string // the expected output, with its lines separated by "|"
+}
+
+func runCases(t *testing.T, cases []scriptCase) {
+	for _,c := range cases {
+		out,err := runModule(t,c.src)
+		if err!=nil {
+			t.Errorf("%s: %v",c.name,err)
+			continue
+		}
+		if want := strings.Join(strings.Split(c.out,"|"),"\n")+"\n"; out!=want {
+			t.Errorf("%s: got %q, want %q",c.name,out,want)
+		}
+	}
+}
+
+func TestListAssign(t *testing.T) {
+	runCases(t,[]scriptCase{
+		{"fewer values", `my ($a, $b) = (1); print $a . "," . $b;`, "1,"},
+		{"no values", `my ($c, @d) = (); print "[" . $c . "] " . ($#d + 1);`, "[] 0"},
+		{"assign", `my ($x, $y, $z); ($x, $y, $z) = (7, 8); print $x . $y . "[" . $z . "]";`, "78[]"},
+		{"array rest", `my ($p, @q) = (1); print $p . " " . ($#q + 1);`, "1 0"},
+	})
+}
+
+func TestRegexEngine(t *testing.T) {
+	defer func(d int) { backtrack.DefaultDepthLimit = d }(backtrack.DefaultDepthLimit)
+	backtrack.DefaultDepthLimit = 1000
+	runCases(t,[]scriptCase{
+		{"deep backtracking", `
+my $s = "";
+my $i = 0;
+while ($i < 1000) { $s = $s . "word "; $i++; }
+eval { $s =~ /(?<=^)(?:(\w+)\s?)*/; };
+print ($@ =~ /recursion limit/ ? "caught" : "no error");`, "caught"},
+		{"runtime pattern with use re", `
+use re qw(re2);
+my $p = "(?<=a)b";
+eval { "ab" =~ $p; };
+print ($@ =~ /Invalid regex/ ? "re2" : "fallback");`, "re2"},
+		{"runtime pattern", `
+my $p = "(?<=a)b";
+print ("ab" =~ $p ? "fallback" : "no match");`, "fallback"},
+	})
+}
+
+func TestLoopControl(t *testing.T) {
+	runCases(t,[]scriptCase{
+		{"last in a loop of a sub", `
+sub f { my @l = (1,2,3); for my $i (@l) { last if $i==2; print $i; } return 9; }
+my @m = (1,2);
+for my $j (@m) { print f(); }`, "1|9|1|9"},
+		{"next in a nested loop", `
+my @l = (1,2);
+for my $i (@l) { for my $j (@l) { next if $j==1; print $i . $j; } }`, "12|22"},
+	})
+	_,err := runModule(t,`sub f { last; } f(); my @l = (1,2,3); for my $i (@l) { print $i; }`)
+	if err==nil || !strings.Contains(fmt.Sprint(err),`Can't "last" outside a loop block`) {
+		t.Errorf("last outside a loop: got %v",err)
+	}
+}
+
+func TestPos(t *testing.T) {
+	runCases(t,[]scriptCase{
+		{"redeclared in a loop", `
+my @l = (1,2);
+for my $i (@l) { my $s = "aaa"; $s =~ /a/g; print pos($s); }`, "1|1"},
+		{"same value assigned", `
+my $t = "aaa"; $t =~ /a/g; $t = "aaa"; $t =~ /a/g; print pos($t);`, "1"},
+		{"copy", `
+my $t = "aaa"; $t =~ /a/g; $t =~ /a/g; my $c = $t; print pos($t) . "[" . pos($c) . "]";`, "2[]"},
+		{"iterate", `
+my $s = "a1b2"; my $n = 0;
+while ($s =~ /\d/g) { $n = $n + 1; print pos($s); }
+print "n " . $n;`, "2|4|n 2"},
+		{"number", `
+my $x = 1212; $x =~ /2/g; print pos($x) . " " . ($x + 1);`, "2 1213"},
+	})
+}
+
+/*
+A statement in void context must give back its temporaries, so repeating it doesn't
+grow the register set of the sub.
+*/
+func TestPosRegisters(t *testing.T) {
+	dir := t.TempDir()
+	src := `
+sub a1 { my $s = "x"; pos($s); }
+sub a4 { my $s = "x"; pos($s); pos($s); pos($s); pos($s); }
+sub h1 { my %h; pos($h{a}); }
+sub h4 { my %h; pos($h{a}); pos($h{a}); pos($h{a}); pos($h{a}); }
+sub w1 { my $s = "x"; pos($s) = 1; }
+sub w4 { my $s = "x"; pos($s) = 1; pos($s) = 1; pos($s) = 1; pos($s) = 1; }
+`
+	if e := ioutil.WriteFile(fpath.Join(dir,"T.dm"),[]byte(src),0644); e!=nil { t.Fatal(e) }
+	cl := new(vm.ClassLoader)
+	cl.Spi = &SpecificLoader{GL: CreateGenericLoader(), Paths: []string{dir}}
+	vm.LoadModule(cl.GetModule("T").(*values.ScModule),vm.NewThreadState())
+	v,_ := cl.Modules.Load("T")
+	md := v.(*vm.Module)
+	mets := func(name string) vm.RSMetrics {
+		p,ok := md.Procedures.Load(name)
+		if !ok { t.Fatalf("sub %s not found",name) }
+		return p.(*vm.Procedure).Mets
+	}
+	for _,n := range []string{"a","h","w"} {
+		if one,four := mets(n+"1"),mets(n+"4"); one!=four {
+			t.Errorf("%s: %v registers for one statement, %v for four",n,one,four)
+		}
+	}
+}
+
+func TestEval(t *testing.T) {
+	runCases(t,[]scriptCase{
+		{"position", `
+sub g { my $x = 1/0; }
+eval { g(); };
+print ($@ =~ /^Illegal division by zero at T\.dm:2:/ ? "ok" : $@);`, "ok"},
+		{"call site of a signature error", `
+sub f($a) { return $a; }
+eval { f(); };
+print ($@ =~ /called at T\.dm:3:/ ? "ok" : $@);`, "ok"},
+		{"no error", `eval { 1; }; print "[" . $@ . "]";`, "[]"},
+	})
+}
+
+func TestStrictSubs(t *testing.T) {
+	out,err := runModules(t,map[string]string{
+		"T": `use strict; require O; print O::f(); sub g { return O::f(); } print g(); package P; print O::f();`,
+		"O": `sub f { return "f of O"; }`,
+	})
+	if err!=nil || out!="f of O\nf of O\nf of O\n" { t.Errorf("qualified call: got %q, %v",out,err) }
+	_,err = runModule(t,`use strict; g();`)
+	if err==nil || !strings.Contains(fmt.Sprint(err),"Undefined subroutine &T::g called") {
+		t.Errorf("undefined sub: got %v",err)
+	}
+}
+
+func TestReturn(t *testing.T) {
+	runCases(t,[]scriptCase{
+		{"declaration", `
+sub f { return 4; }
+sub g { return my $x = f(); }
+my @l = g(); my $s = g();
+print $s . " " . $l[0];`, "4 4"},
+		{"list declaration", `
+sub h { return my ($a, $b) = (1, 2); }
+my @l = h();
+print $l[0] . $l[1];`, "12"},
+	})
+}
+
+func TestNoAutovivification(t *testing.T) {
+	runCases(t,[]scriptCase{
+		{"m//g", `my %h; $h{k} =~ /x/g; print (exists $h{k} ? "created" : "ok");`, "ok"},
+		{"m//g nested", `my %h; $h{a}{b} =~ /x/g; print (exists $h{a} ? "created" : "ok");`, "ok"},
+		{"m//g in list context", `my %h; my @m = ($h{k} =~ /x/g); print (exists $h{k} ? "created" : "ok");`, "ok"},
+		{"m//g array", `my @a; $a[3] =~ /x/g; print $#a + 1;`, "0"},
+		{"pos", `my %h; my $p = pos($h{a}{b}); print (exists $h{a} ? "created" : "ok");`, "ok"},
+		{"pos of an element", `my %h; $h{k} = "aa"; $h{k} =~ /a/g; print pos($h{k});`, "1"},
+		{"pos()=", `my %h; pos($h{k}) = 0; print (exists $h{k} ? "created" : "ok");`, "created"},
+	})
+}
+
+func TestSubstitution(t *testing.T) {
+	runCases(t,[]scriptCase{
+		{"hash element", `my %h = ("x", "X"); my $s = "abc"; $s =~ s/a/$h{x}/; print $s;`, "Xbc"},
+		{"nested elements", `
+my $r = [1, {"k" => "K"}]; my @a = ("A", "B"); my $s = "abc";
+$s =~ s/b/<$a[1],$r->[1]{k},$r->[1]->{k}>/; print $s;`, "a<B,K,K>c"},
+		{"array", `my @a = (1, 2); my $s = "abc"; $s =~ s/b/[@a]/; print $s;`, "a[1 2]c"},
+		{"no subscripts", `my $s = "abc"; $s =~ s/(b)/$1[0],${1}[0]/; print $s;`, "ab[0],b[0]c"},
+	})
+}
+
+/*
+Runs the scripts in t/. Every line they print starts with "ok" or "not ok", as in Perl's
+test scripts.
+*/
+func TestScripts(t *testing.T) {
+	files,e := fpath.Glob(fpath.Join("..","t","*.dm"))
+	if e!=nil { t.Fatal(e) }
+	if len(files)==0 { t.Fatal("no scripts in t/") }
+	for _,f := range files {
+		name := strings.TrimSuffix(fpath.Base(f),".dm")
+		t.Run(name,func(t *testing.T) {
+			cl := new(vm.ClassLoader)
+			cl.Spi = &SpecificLoader{GL: CreateGenericLoader(), Paths: []string{fpath.Dir(f)}}
+			out,err := capture(t,func() {
+				if _,ok := vm.LoadModule(cl.GetModule(name).(*values.ScModule),vm.NewThreadState()); !ok { t.Fatal("module not found") }
+			})
+			if err!=nil { t.Fatal(err) }
+			for _,line := range strings.Split(strings.TrimSuffix(out,"\n"),"\n") {
+				if !strings.HasPrefix(line,"ok") { t.Error(line) }
+			}
+		})
+	}
+}
+
+func TestFailedRequire(t *testing.T) {
+	for _,c := range []scriptCase{
+		{"runtime error", `package P; sub f { return 1; } package O; my $x = 1/0;`, "not installed"},
+		{"compile error", `use strict; package P; sub f { return 1; } print $y;`, "not installed"},
+		{"loaded", `package P; sub f { return 1; }`, "installed"},
+	} {
+		out,err := runModules(t,map[string]string{
+			"T": `eval { require O; }; eval { P::f(); }; print ($@ ? "not installed" : "installed");`,
+			"O": c.src,
+		})
+		if err!=nil || out!=c.out+"\n" { t.Errorf("%s: got %q, %v",c.name,out,err) }
+	}
+}
@@ -85,11 +85,11 @@ func (av *AV) Store(i int64) *Scalar {
 	return &avd[i]
 }
 func (av *AV) StoreSlot(i int64) ScalarSlot {
+	grow := int64(len(*av))<=i
 	p := av.Store(i)
 	if p==nil { return nil }
-	*p = null
+	if grow || *p==nil { *p = null } // keep existing elements
 	return &slotAV{p}
-	return nil
 }
 
 func (av *AV) Push(s Scalar) { *av = append(*av,s) }
@@ -100,11 +100,35 @@ func (av *AV) Pop(s Scalar) Scalar {
 	return r
 }
 
+/* Dereferences an array reference (@$ref). Panics, if s is none. */
+func DerefAV(s Scalar) *AV {
+	if r,ok := s.(*ScReference); ok {
+		if av,ok := r.Data.(*AV); ok { return av }
+	}
+	panic(derefError(s,"an ARRAY"))
+}
+
+/*
+Dereferences the array reference in a slot. If the slot is undefined, a new array is
+created and a reference to it is stored into the slot (autovivification).
+*/
+func VivifyAV(slot ScalarSlot) *AV {
+	s := slot.Get()
+	if s==nil || s.Type()==T_Nil {
+		av := new(AV)
+		r := AllocScReference()
+		r.Data = av
+		slot.Set(r)
+		return av
+	}
+	return DerefAV(s)
+}
+
 func Av_Index(ref, idx Scalar) Scalar {
-	return ref.(*ScReference).Data.(*AV).Fetch(idx.Integer(),false)
+	return DerefAV(ref).Fetch(idx.Integer(),false)
 }
 func Av_IndexSlot(ref, idx Scalar) ScalarSlot {
-	av := ref.(*ScReference).Data.(*AV)
+	av := DerefAV(ref)
 	i := idx.Integer()
 	sl := av.FetchSlot(i,false)
 	if sl==nil { sl = av.StoreSlot(i) }
@@ -0,0 +1,202 @@
+/*
+Copyright (c) 2021 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package values
+
+import "math"
+import "math/big"
+import "strings"
+import "strconv"
+
+/*
+Arbitrary-precision numbers. Integer arithmetic, that overflows int64, yields an ScBigInt,
+and ScBigInt results, that fit into int64, become ScInt again. Float literals with more
+significant digits, than a float64 can hold, yield an ScBigFloat, a binary float with
+BigFloatPrec bits of mantissa.
+
+The values are immutable: the arithmetic always allocates new ones.
+*/
+type ScBigInt struct{ I *big.Int }
+type ScBigFloat struct{ F *big.Float }
+
+/* The precision (mantissa bits) of ScBigFloat. */
+const BigFloatPrec = 256
+
+var _ Scalar = ScBigInt{}
+func (ScBigInt) Type() Type { return T_BigInt }
+func (ScBigInt) IsFloat() bool { return false }
+func (v ScBigInt) Integer() int64 {
+	if v.I.IsInt64() { return v.I.Int64() }
+	if v.I.Sign()<0 { return math.MinInt64 }
+	return math.MaxInt64
+}
+func (v ScBigInt) Float() float64 { f,_ := new(big.Float).SetInt(v.I).Float64(); return f }
+func (ScBigInt) IsBytes() bool { return false }
+func (v ScBigInt) String() string { return v.I.String() }
+func (v ScBigInt) Bytes() []byte { return v.I.Append(nil,10) }
+func (v ScBigInt) AppendTo(prefix []byte) []byte { return v.I.Append(prefix,10) }
+func (v ScBigInt) Less(s Scalar) bool { return v.I.Cmp(s.(ScBigInt).I)<0 }
+func (v ScBigInt) Bool() bool { return v.I.Sign()!=0 }
+
+var _ Scalar = ScBigFloat{}
+func (ScBigFloat) Type() Type { return T_BigFloat }
+func (ScBigFloat) IsFloat() bool { return true }
+func (v ScBigFloat) Integer() int64 {
+	i,_ := v.F.Int64()
+	return i
+}
+func (v ScBigFloat) Float() float64 { f,_ := v.F.Float64(); return f }
+func (ScBigFloat) IsBytes() bool { return false }
+func (v ScBigFloat) String() string { return v.F.Text('f',-1) }
+func (v ScBigFloat) Bytes() []byte { return v.F.Append(nil,'f',-1) }
+func (v ScBigFloat) AppendTo(prefix []byte) []byte { return v.F.Append(prefix,'f',-1) }
+func (v ScBigFloat) Less(s Scalar) bool { return v.F.Cmp(s.(ScBigFloat).F)<0 }
+func (v ScBigFloat) Bool() bool { return v.F.Sign()!=0 }
+
+/* Returns z as ScInt, if it fits, as ScBigInt otherwise. */
+func BigInt(z *big.Int) Scalar {
+	if z.IsInt64() { return ScInt(z.Int64()) }
+	return ScBigInt{z}
+}
+func BigFloat(f *big.Float) Scalar { return ScBigFloat{f} }
+
+/* Parses an integer literal (with 0x, 0o, 0b or 0 prefix), that may exceed int64. */
+func ParseInt(text string) Scalar {
+	z,ok := new(big.Int).SetString(text,0)
+	if !ok { return ScInt(0) }
+	return BigInt(z)
+}
+
+/*
+Parses a float literal. Literals with more than 17 significant digits (which a float64 can't
+tell apart) yield an ScBigFloat.
+*/
+func ParseFloat(text string) Scalar {
+	digits := strings.TrimLeft(text,"+-0.")
+	if i := strings.IndexAny(digits,"eE"); i>=0 { digits = digits[:i] }
+	digits = strings.TrimRight(strings.Replace(digits,".","",1),"0")
+	if len(digits)>17 {
+		if f,_,err := big.ParseFloat(text,0,BigFloatPrec,big.ToNearestEven); err==nil { return ScBigFloat{f} }
+	}
+	f,_ := strconv.ParseFloat(text,64)
+	return ScFloat(f)
+}
+
+// The kind of arithmetic, an operand requires. The result has the largest kind of its operands.
+const (
+	k_int = iota
+	k_bigint
+	k_float
+	k_bigfloat
+)
+func numKind(s Scalar) int {
+	switch s.(type) {
+	case ScInt: return k_int
+	case ScBigInt: return k_bigint
+	case ScBigFloat: return k_bigfloat
+	}
+	if s.IsFloat() { return k_float }
+	return k_int
+}
+func numKind2(a, b Scalar) int {
+	ka,kb := numKind(a),numKind(b)
+	if ka>kb { return ka }
+	return kb
+}
+
+func toBigInt(s Scalar) *big.Int {
+	switch v := s.(type) {
+	case ScBigInt: return v.I
+	case ScBigFloat:
+		z,_ := v.F.Int(nil)
+		return z
+	}
+	return big.NewInt(s.Integer())
+}
+func toBigFloat(s Scalar) *big.Float {
+	f := new(big.Float).SetPrec(BigFloatPrec)
+	switch v := s.(type) {
+	case ScBigFloat: return f.Set(v.F)
+	case ScBigInt: return f.SetInt(v.I)
+	case ScInt: return f.SetInt64(int64(v))
+	}
+	x := s.Float()
+	if math.IsNaN(x) { panic("Can't use NaN in arbitrary-precision arithmetic") }
+	return f.SetFloat64(x)
+}
+
+// Arithmetic on big numbers. op is one of + - * /.
+func bigArith(op byte, a, b Scalar) Scalar {
+	if numKind2(a,b)==k_bigfloat {
+		x,y := toBigFloat(a),toBigFloat(b)
+		switch op {
+		case '+': x.Add(x,y)
+		case '-': x.Sub(x,y)
+		case '*': x.Mul(x,y)
+		case '/': x.Quo(x,y)
+		}
+		return ScBigFloat{x}
+	}
+	x,y := toBigInt(a),toBigInt(b)
+	z := new(big.Int)
+	switch op {
+	case '+': z.Add(x,y)
+	case '-': z.Sub(x,y)
+	case '*': z.Mul(x,y)
+	case '/':
+		// As with int64, an inexact quotient isn't an integer.
+		if _,m := z.QuoRem(x,y,new(big.Int)); m.Sign()!=0 {
+			return ScBigFloat{new(big.Float).SetPrec(BigFloatPrec).Quo(toBigFloat(a),toBigFloat(b))}
+		}
+	}
+	return BigInt(z)
+}
+
+/*
+Compares numbers of different types by their value. ok is false, if either is no number,
+or a NaN.
+*/
+func numCompare(a, b Scalar) (c int, ok bool) {
+	for _,s := range []Scalar{a,b} {
+		switch s.Type() {
+		case T_Integer,T_BigInt,T_BigFloat:
+		case T_Float: if math.IsNaN(s.Float()) { return 0,false }
+		default: return 0,false
+		}
+	}
+	return toBigFloat(a).Cmp(toBigFloat(b)),true
+}
+
+type bigIntKey string
+type bigFloatKey string
+
+// The hash key of a big number. A big float, that is exactly a float64, has the key of that float.
+func bigKey(s Scalar) interface{} {
+	switch v := s.(type) {
+	case ScBigInt: return bigIntKey(v.I.String())
+	case ScBigFloat:
+		if f,acc := v.F.Float64(); acc==big.Exact { return f }
+		return bigFloatKey(v.F.Text('p',0))
+	}
+	return nil
+}
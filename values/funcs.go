@@ -24,45 +24,177 @@ SOFTWARE.
 package values
 
 import "math"
+import "math/big"
 
+/*
+The arithmetic operators. The operands are numified (see Numify). Integers, that overflow
+int64, are promoted to ScBigInt, and operands of type ScBigInt or ScBigFloat make the
+operation arbitrary-precision.
+*/
 func Add(a, b Scalar) Scalar {
-	if a.IsFloat() || b.IsFloat() {
+	a,b = Numify(a),Numify(b)
+	switch numKind2(a,b) {
+	case k_int:
+		x,y := a.Integer(),b.Integer()
+		z := x+y
+		if (x^z)&(y^z)>=0 { return ScInt(z) }
+	case k_float:
 		return ScFloat(a.Float()+b.Float())
-	} else {
-		return ScInt(a.Integer()+b.Integer())
 	}
+	return bigArith('+',a,b)
 }
 
 func Sub(a, b Scalar) Scalar {
-	if a.IsFloat() || b.IsFloat() {
+	a,b = Numify(a),Numify(b)
+	switch numKind2(a,b) {
+	case k_int:
+		x,y := a.Integer(),b.Integer()
+		z := x-y
+		if (x^y)&(x^z)>=0 { return ScInt(z) }
+	case k_float:
 		return ScFloat(a.Float()-b.Float())
-	} else {
-		return ScInt(a.Integer()-b.Integer())
 	}
+	return bigArith('-',a,b)
 }
 
 func Mul(a, b Scalar) Scalar {
-	if a.IsFloat() || b.IsFloat() {
+	a,b = Numify(a),Numify(b)
+	switch numKind2(a,b) {
+	case k_int:
+		x,y := a.Integer(),b.Integer()
+		z := x*y
+		if x==0 || (z/x==y && !(x==-1 && y==math.MinInt64) && !(y==-1 && x==math.MinInt64)) { return ScInt(z) }
+	case k_float:
 		return ScFloat(a.Float()*b.Float())
-	} else {
-		return ScInt(a.Integer()*b.Integer())
 	}
+	return bigArith('*',a,b)
 }
 
+/* Division, as in Perl: the quotient of integers is an integer only, if it is exact. */
 func Div(a, b Scalar) Scalar {
-	if a.IsFloat() || b.IsFloat() {
+	a,b = Numify(a),Numify(b)
+	if !b.Bool() { panic("Illegal division by zero") }
+	switch numKind2(a,b) {
+	case k_int:
+		x,y := a.Integer(),b.Integer()
+		if x%y==0 && !(x==math.MinInt64 && y==-1) { return ScInt(x/y) }
+		return ScFloat(float64(x)/float64(y))
+	case k_float:
 		return ScFloat(a.Float()/b.Float())
-	} else {
-		return ScInt(a.Integer()/b.Integer())
 	}
+	return bigArith('/',a,b)
 }
 
+/*
+The modulus, as in Perl: the operands are truncated to integers, and a non-zero result has
+the sign of the right operand.
+*/
 func Mod(a, b Scalar) Scalar {
-	if a.IsFloat() || b.IsFloat() {
-		return ScFloat(math.Remainder(a.Float(),b.Float()))
-	} else {
-		return ScInt(a.Integer()%b.Integer())
+	a,b = Int(a),Int(b)
+	if a.Type()==T_Float || b.Type()==T_Float { return ScFloat(math.NaN()) } // Inf or NaN
+	if !b.Bool() { panic("Illegal modulus zero") }
+	if x,ok := a.(ScInt); ok {
+		if y,ok := b.(ScInt); ok {
+			r := x%y
+			if r!=0 && (r<0)!=(y<0) { r += y }
+			return r
+		}
+	}
+	x,y := toBigInt(a),toBigInt(b)
+	r := new(big.Int).Rem(x,y)
+	if r.Sign()!=0 && r.Sign()!=y.Sign() { r.Add(r,y) }
+	return BigInt(r)
+}
+
+/*
+Exponentiation (**). Integers with a non-negative integer exponent yield an exact integer,
+everything else a float.
+*/
+func Pow(a, b Scalar) Scalar {
+	a,b = Numify(a),Numify(b)
+	k := numKind2(a,b)
+	if numKind(b)<=k_bigint && b.Integer()>=0 && k!=k_float {
+		if k==k_bigfloat {
+			x := toBigFloat(a)
+			r := new(big.Float).SetPrec(BigFloatPrec).SetInt64(1)
+			for n := b.Integer(); n>0; n >>= 1 {
+				if n&1!=0 { r.Mul(r,x) }
+				x.Mul(x,x)
+			}
+			return ScBigFloat{r}
+		}
+		x := toBigInt(a)
+		// Results with more than 16M bits are beyond any reasonable use, they become Inf.
+		if n := b.Integer(); x.CmpAbs(big.NewInt(1))<=0 || n<=(1<<24)/int64(x.BitLen()) {
+			return BigInt(new(big.Int).Exp(x,toBigInt(b),nil))
+		}
 	}
+	return ScFloat(math.Pow(a.Float(),b.Float()))
+}
+
+/* int EXPR: the integer part of a number, truncated towards zero. */
+func Int(a Scalar) Scalar {
+	a = Numify(a)
+	switch v := a.(type) {
+	case ScBigFloat:
+		z,_ := v.F.Int(nil)
+		return BigInt(z)
+	case ScFloat:
+		f := math.Trunc(float64(v))
+		if math.IsNaN(f) || math.IsInf(f,0) { return v }
+		if math.Abs(f)<(1<<63) { return ScInt(int64(f)) }
+		z,_ := big.NewFloat(f).Int(nil)
+		return BigInt(z)
+	}
+	return a
+}
+
+// The integers, the bitwise operators work on.
+func bitOperands(a, b Scalar) (Scalar,Scalar,bool) {
+	a,b = Int(a),Int(b)
+	if a.Type()==T_Float || b.Type()==T_Float { return ScInt(0),ScInt(0),false } // Inf or NaN
+	return a,b,a.Type()==T_Integer && b.Type()==T_Integer
+}
+
+/* The bitwise operators & | ^ on (signed) integers. */
+func BitAnd(a, b Scalar) Scalar {
+	a,b,small := bitOperands(a,b)
+	if small { return ScInt(a.Integer()&b.Integer()) }
+	return BigInt(new(big.Int).And(toBigInt(a),toBigInt(b)))
+}
+func BitOr(a, b Scalar) Scalar {
+	a,b,small := bitOperands(a,b)
+	if small { return ScInt(a.Integer()|b.Integer()) }
+	return BigInt(new(big.Int).Or(toBigInt(a),toBigInt(b)))
+}
+func BitXor(a, b Scalar) Scalar {
+	a,b,small := bitOperands(a,b)
+	if small { return ScInt(a.Integer()^b.Integer()) }
+	return BigInt(new(big.Int).Xor(toBigInt(a),toBigInt(b)))
+}
+
+/*
+The shift operators << and >>. Shifting left promotes to ScBigInt, rather than losing bits,
+and shifting right is arithmetic. A negative count shifts in the other direction.
+*/
+func ShiftLeft(a, b Scalar) Scalar {
+	a,b,_ = bitOperands(a,b)
+	n := b.Integer()
+	if n<0 { return ShiftRight(a,ScInt(-n)) }
+	if x,ok := a.(ScInt); ok && n<63 && (x<<uint(n))>>uint(n)==x { return x<<uint(n) }
+	if n>1<<24 { panic("Shift count too large") }
+	return BigInt(new(big.Int).Lsh(toBigInt(a),uint(n)))
+}
+func ShiftRight(a, b Scalar) Scalar {
+	a,b,_ = bitOperands(a,b)
+	n := b.Integer()
+	if n<0 { return ShiftLeft(a,ScInt(-n)) }
+	if x,ok := a.(ScInt); ok {
+		if n>63 { n = 63 }
+		return x>>uint(n)
+	}
+	if n>1<<32 { n = 1<<32 }
+	return BigInt(new(big.Int).Rsh(toBigInt(a),uint(n)))
 }
 
 func Concat(a, b Scalar) Scalar {
@@ -78,30 +210,72 @@ func ForceTrue(a Scalar) Scalar {
 func And(a, b Scalar) Scalar { return Bool2S(a.Bool() && b.Bool()) }
 func Or(a, b Scalar) Scalar { return Bool2S(a.Bool() && b.Bool()) }
 
-func LT(a, b Scalar) Scalar { return Bool2S(ScalarLess(a,b)) }
-func GT(a, b Scalar) Scalar { return Bool2S(ScalarLess(b,a)) }
-func LE(a, b Scalar) Scalar { return Bool2S(!ScalarLess(b,a)) }
-func GE(a, b Scalar) Scalar { return Bool2S(!ScalarLess(a,b)) }
-
-func EQ(a, b Scalar) Scalar { return Bool2S(ScalarComp(a,b)==0) }
-func NE(a, b Scalar) Scalar { return Bool2S(ScalarComp(a,b)!=0) }
-func Comp(a, b Scalar) Scalar { return ScInt(ScalarComp(a,b)) }
-
 func UPlus(a Scalar) Scalar {
+	switch a.(type) {
+	case ScBigInt,ScBigFloat: return a
+	}
 	if a.IsFloat() { return ScFloat(a.Float()) }
 	return ScInt(a.Integer())
 }
 func UMinus(a Scalar) Scalar {
+	switch v := a.(type) {
+	case ScBigInt: return BigInt(new(big.Int).Neg(v.I))
+	case ScBigFloat: return ScBigFloat{new(big.Float).Neg(v.F)}
+	}
 	if a.IsFloat() { return -ScFloat(a.Float()) }
+	if i := a.Integer(); i==math.MinInt64 { return ScBigInt{new(big.Int).Neg(big.NewInt(i))} }
 	return -ScInt(a.Integer())
 }
 func UNot(a Scalar) Scalar { return Bool2S(!a.Bool()) }
-func UBitInv(a Scalar) Scalar { return ScInt(^a.Integer()) }
+func UBitInv(a Scalar) Scalar {
+	if v,ok := a.(ScBigInt); ok { return BigInt(new(big.Int).Not(v.I)) }
+	return ScInt(^a.Integer())
+}
+
 
+/*
+++ and --. As in Perl, incrementing a non-empty string, that matches /^[a-zA-Z]*[0-9]*$/, is
+magic: "aa9" becomes "ab0", "Az" becomes "Ba" and "zz" becomes "aaa". undef increments to 1.
+*/
+func Inc(a Scalar) Scalar {
+	switch a.Type() {
+	case T_Nil: return ScInt(1)
+	case T_String,T_Buffer:
+		if b,ok := magicInc(a.Bytes()); ok {
+			if a.IsBytes() { return ScBuffer(b) }
+			return ScString(b)
+		}
+	}
+	return Add(a,ScInt(1))
+}
+func Dec(a Scalar) Scalar { return Sub(a,ScInt(1)) }
+
+func magicInc(s []byte) ([]byte,bool) {
+	if len(s)==0 { return nil,false }
+	i := 0
+	for i<len(s) && (s[i]>='a' && s[i]<='z' || s[i]>='A' && s[i]<='Z') { i++ }
+	if i==0 { return nil,false } // a number
+	for i<len(s) && s[i]>='0' && s[i]<='9' { i++ }
+	if i<len(s) { return nil,false }
+	b := append([]byte(nil),s...)
+	for i = len(b)-1; i>=0; i-- {
+		switch b[i] {
+		case '9': b[i] = '0'
+		case 'z': b[i] = 'a'
+		case 'Z': b[i] = 'A'
+		default:
+			b[i]++
+			return b,true
+		}
+	}
+	// Carry out of the first character: "zz" becomes "aaa", "Zz" becomes "AAa".
+	return append([]byte{b[0]},b...),true
+}
 
 func ScalarLess(a, b Scalar) bool {
 	at,bt := a.Type(),b.Type()
 	if at==bt { return a.Less(b) }
+	if c,ok := numCompare(a,b); ok { return c<0 } // numbers of different types
 	return at<bt
 }
 func ScalarComp(a, b Scalar) int {
@@ -111,6 +285,7 @@ func ScalarComp(a, b Scalar) int {
 		if b.Less(a) { return 1 }
 		return 0
 	}
+	if c,ok := numCompare(a,b); ok { return c }
 	if at<bt { return -1 }
 	return 1
 }
@@ -40,6 +40,7 @@ func Hv_Key(s Scalar) interface{} {
 	case ScString: return string(v)
 	case ScBuffer: return string(v) // Should not happen.
 	case *ScReference: return v.Refid
+	case ScBigInt,ScBigFloat: return bigKey(v)
 	}
 	return s.String()
 }
@@ -110,8 +111,16 @@ func (hv *HV) FromAV(av *AV) {
 		} else { // if not, the slot is exthausted and must be replaced.
 			slot = new(slotHV)
 		}
+		key = nil
 	}
 }
+func (hv *HV) Len() (n int) {
+	hv.Map.Range(func(key, value interface{}) bool{
+		n++
+		return true
+	})
+	return
+}
 func (hv *HV) Clear(){
 	var res = make([]interface{},0,16)
 	hv.Map.Range(func(key, value interface{}) bool{
@@ -130,12 +139,36 @@ func (hv *HV) FromHV(hv2 *HV) {
 }
 
 
+/* Dereferences a hash reference (%$ref). Panics, if s is none. */
+func DerefHV(s Scalar) *HV {
+	if r,ok := s.(*ScReference); ok {
+		if hv,ok := r.Data.(*HV); ok { return hv }
+	}
+	panic(derefError(s,"a HASH"))
+}
+
+/*
+Dereferences the hash reference in a slot. If the slot is undefined, a new hash is
+created and a reference to it is stored into the slot (autovivification).
+*/
+func VivifyHV(slot ScalarSlot) *HV {
+	s := slot.Get()
+	if s==nil || s.Type()==T_Nil {
+		hv := new(HV)
+		r := AllocScReference()
+		r.Data = hv
+		slot.Set(r)
+		return hv
+	}
+	return DerefHV(s)
+}
+
 func Hv_Index(ref, idx Scalar) Scalar {
-	slot := ref.(*ScReference).Data.(*HV).Get(idx)
+	slot := DerefHV(ref).Get(idx)
 	if slot==nil { return null }
 	return slot.Get()
 }
 func Hv_IndexSlot(ref, idx Scalar) ScalarSlot {
-	return ref.(*ScReference).Data.(*HV).Put(idx)
+	return DerefHV(ref).Put(idx)
 }
 
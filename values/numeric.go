@@ -0,0 +1,159 @@
+/*
+Copyright (c) 2021 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package values
+
+import "math"
+import "strings"
+
+/*
+Parses the number at the start of s, as Perl does: leading whitespace, a sign, digits with an
+optional fraction and exponent, or Inf/Infinity/NaN. n is the number of bytes consumed, 0 if
+there is no number.
+*/
+func parseNumber(s string) (v Scalar, n int) {
+	i := 0
+	for i<len(s) && strings.IndexByte(" \t\n\r\f\v",s[i])>=0 { i++ }
+	start := i
+	if i<len(s) && (s[i]=='+' || s[i]=='-') { i++ }
+	for _,w := range []string{"infinity","inf","nan"} {
+		if len(s)-i>=len(w) && strings.EqualFold(s[i:i+len(w)],w) {
+			f := math.Inf(1)
+			if w=="nan" { f = math.NaN() } else if s[start]=='-' { f = math.Inf(-1) }
+			return ScFloat(f),i+len(w)
+		}
+	}
+	digits := 0
+	for i<len(s) && s[i]>='0' && s[i]<='9' { i++; digits++ }
+	isint := true
+	if i<len(s) && s[i]=='.' {
+		j := i+1
+		for j<len(s) && s[j]>='0' && s[j]<='9' { j++; digits++ }
+		if digits>0 { i,isint = j,false }
+	}
+	if digits==0 { return ScInt(0),0 }
+	if i<len(s) && (s[i]=='e' || s[i]=='E') {
+		j := i+1
+		if j<len(s) && (s[j]=='+' || s[j]=='-') { j++ }
+		if j<len(s) && s[j]>='0' && s[j]<='9' {
+			for j<len(s) && s[j]>='0' && s[j]<='9' { j++ }
+			i,isint = j,false
+		}
+	}
+	text := s[start:i]
+	if isint {
+		neg := text[0]=='-'
+		text = strings.TrimLeft(strings.TrimLeft(text,"+-"),"0")
+		if text=="" { return ScInt(0),i }
+		if neg { text = "-"+text }
+		return ParseInt(text),i // no 0x or 0 prefix is left
+	}
+	return ParseFloat(text),i
+}
+
+/*
+Converts a scalar into a number. Strings yield their leading number ("10abc" is 10, "abc"
+is 0), undef is 0 and references are their address.
+*/
+func Numify(s Scalar) Scalar {
+	switch s.Type() {
+	case T_Integer,T_Float,T_BigInt,T_BigFloat: return s
+	case T_String,T_Buffer:
+		v,_ := parseNumber(s.String())
+		return v
+	}
+	return ScInt(s.Integer())
+}
+
+/*
+Reports, whether s is a number or a string, that is one as a whole (surrounding whitespace
+is allowed), like Scalar::Util::looks_like_number.
+*/
+func LooksLikeNumber(s Scalar) bool {
+	switch s.Type() {
+	case T_Integer,T_Float,T_BigInt,T_BigFloat: return true
+	case T_String,T_Buffer:
+		str := s.String()
+		_,n := parseNumber(str)
+		return n>0 && strings.TrimSpace(str[n:])==""
+	}
+	return false
+}
+
+// Compares a and b numerically. ok is false, if either is NaN.
+func numComp(a, b Scalar) (c int, ok bool) {
+	x,y := Numify(a),Numify(b)
+	if i,ok := x.(ScInt); ok {
+		if j,ok := y.(ScInt); ok {
+			if i<j { return -1,true }
+			if i>j { return 1,true }
+			return 0,true
+		}
+	}
+	if x.Type()==T_Float && y.Type()==T_Float {
+		f,g := x.Float(),y.Float()
+		switch {
+		case f<g: return -1,true
+		case f>g: return 1,true
+		case f==g: return 0,true
+		}
+		return 0,false
+	}
+	return numCompare(x,y)
+}
+
+/* The numeric comparison operators: == != < > <= >= and <=>, which yields undef for NaN. */
+func NumEQ(a, b Scalar) Scalar { c,ok := numComp(a,b); return Bool2S(ok && c==0) }
+func NumNE(a, b Scalar) Scalar { c,ok := numComp(a,b); return Bool2S(!ok || c!=0) }
+func NumLT(a, b Scalar) Scalar { c,ok := numComp(a,b); return Bool2S(ok && c<0) }
+func NumGT(a, b Scalar) Scalar { c,ok := numComp(a,b); return Bool2S(ok && c>0) }
+func NumLE(a, b Scalar) Scalar { c,ok := numComp(a,b); return Bool2S(ok && c<=0) }
+func NumGE(a, b Scalar) Scalar { c,ok := numComp(a,b); return Bool2S(ok && c>=0) }
+func NumComp(a, b Scalar) Scalar {
+	c,ok := numComp(a,b)
+	if !ok { return null }
+	return ScInt(c)
+}
+
+/*
+The comparison operators of older versions, which didn't tell numbers and strings apart.
+They compare numerically now.
+
+Deprecated: Use NumLT, NumGT, ... or StrLT, StrGT, ... instead.
+*/
+func LT(a, b Scalar) Scalar { return NumLT(a,b) }
+func GT(a, b Scalar) Scalar { return NumGT(a,b) }
+func LE(a, b Scalar) Scalar { return NumLE(a,b) }
+func GE(a, b Scalar) Scalar { return NumGE(a,b) }
+func EQ(a, b Scalar) Scalar { return NumEQ(a,b) }
+func NE(a, b Scalar) Scalar { return NumNE(a,b) }
+func Comp(a, b Scalar) Scalar { return NumComp(a,b) }
+
+/* The string comparison operators: eq ne lt gt le ge and cmp. */
+func StrEQ(a, b Scalar) Scalar { return Bool2S(a.String()==b.String()) }
+func StrNE(a, b Scalar) Scalar { return Bool2S(a.String()!=b.String()) }
+func StrLT(a, b Scalar) Scalar { return Bool2S(a.String()<b.String()) }
+func StrGT(a, b Scalar) Scalar { return Bool2S(a.String()>b.String()) }
+func StrLE(a, b Scalar) Scalar { return Bool2S(a.String()<=b.String()) }
+func StrGE(a, b Scalar) Scalar { return Bool2S(a.String()>=b.String()) }
+func StrComp(a, b Scalar) Scalar { return ScInt(strings.Compare(a.String(),b.String())) }
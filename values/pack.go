@@ -0,0 +1,370 @@
+/*
+Copyright (c) 2021 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package values
+
+import "encoding/binary"
+import "math"
+import "strconv"
+import "strings"
+
+/*
+A template item of pack and unpack: a letter with its modifiers and count.
+*/
+type packItem struct{
+	code byte
+	order binary.ByteOrder // < or >, the native order is little endian
+	bang bool // !: n! and v! are signed
+	count int
+	star bool // the count is *
+}
+
+/*
+Parses a template of pack or unpack. Whitespace is ignored, # starts a comment.
+*/
+func parsePackTemplate(tpl string) (items []packItem) {
+	for i := 0; i<len(tpl); {
+		c := tpl[i]
+		i++
+		switch c {
+		case ' ','\t','\n','\r': continue
+		case '#':
+			for i<len(tpl) && tpl[i]!='\n' { i++ }
+			continue
+		}
+		if !strings.ContainsRune("aAZbBhHcCsSlLqQnNvVfdwxX@",rune(c)) {
+			panic("Invalid type '"+string(c)+"' in pack template")
+		}
+		it := packItem{code:c,order:binary.LittleEndian,count:1}
+		for i<len(tpl) && strings.IndexByte("<>!",tpl[i])>=0 {
+			switch tpl[i] {
+			case '<': it.order = binary.LittleEndian
+			case '>': it.order = binary.BigEndian
+			case '!': it.bang = true
+			}
+			if tpl[i]!='!' && strings.IndexByte("sSlLqQfd",c)<0 {
+				panic("'"+tpl[i:i+1]+"' allowed only after types sSlLqQfd in pack template")
+			}
+			i++
+		}
+		switch {
+		case i<len(tpl) && tpl[i]=='*':
+			it.star = true
+			i++
+		case i<len(tpl) && tpl[i]=='[':
+			end := strings.IndexByte(tpl[i:],']')
+			if end<0 { panic("No group ending character ']' found in pack template") }
+			n,err := strconv.Atoi(tpl[i+1:i+end])
+			if err!=nil { panic("Invalid count in pack template: "+tpl[i:i+end+1]) }
+			it.count = n
+			i += end+1
+		case i<len(tpl) && tpl[i]>='0' && tpl[i]<='9':
+			j := i
+			for j<len(tpl) && tpl[j]>='0' && tpl[j]<='9' { j++ }
+			it.count,_ = strconv.Atoi(tpl[i:j])
+			i = j
+		}
+		items = append(items,it)
+	}
+	return
+}
+
+// The size of an integer or float type.
+func packSize(c byte) int {
+	switch c {
+	case 'c','C': return 1
+	case 's','S','n','v': return 2
+	case 'l','L','N','V','f': return 4
+	case 'q','Q','d': return 8
+	}
+	return 0
+}
+
+/*
+Packs the values into a buffer, according to the template (see perlfunc pack):
+
+	a A Z    string with nulls, spaces or a terminating null
+	b B      bit string, ascending or descending bit order
+	h H      hex string, low or high nybble first
+	c C      signed or unsigned char
+	s S      16 bit signed or unsigned
+	l L      32 bit signed or unsigned
+	q Q      64 bit signed or unsigned
+	n N      16 or 32 bit big endian (signed with !)
+	v V      16 or 32 bit little endian (signed with !)
+	f d      float or double
+	w        BER compressed integer
+	x X @    null byte, back up a byte, null fill or truncate to an absolute position
+
+The native byte order is little endian, < and > select little or big endian for sSlLqQfd.
+A count repeats an item (the length for strings), * uses all remaining values.
+*/
+func Pack(tpl string, args []Scalar) ScBuffer {
+	var buf []byte
+	next := func() Scalar {
+		if len(args)==0 { return null }
+		a := args[0]
+		args = args[1:]
+		return a
+	}
+	for _,it := range parsePackTemplate(tpl) {
+		switch it.code {
+		case 'a','A','Z':
+			s := next().Bytes()
+			n := it.count
+			if it.star {
+				n = len(s)
+				if it.code=='Z' { n++ }
+			}
+			pad := byte(0)
+			if it.code=='A' { pad = ' ' }
+			field := make([]byte,n)
+			copy(field,s)
+			for j := len(s); j<n; j++ { field[j] = pad }
+			if it.code=='Z' && n>0 { field[n-1] = 0 }
+			buf = append(buf,field...)
+		case 'b','B','h','H':
+			buf = append(buf,packBits(it,next().Bytes())...)
+		case 'x':
+			if it.star { continue }
+			buf = append(buf,make([]byte,it.count)...)
+		case 'X':
+			n := it.count
+			if it.star { n = 0 }
+			if n>len(buf) { panic("'X' outside of string in pack") }
+			buf = buf[:len(buf)-n]
+		case '@':
+			if it.star { continue }
+			if it.count<=len(buf) {
+				buf = buf[:it.count]
+			} else {
+				buf = append(buf,make([]byte,it.count-len(buf))...)
+			}
+		case 'w':
+			n := it.count
+			if it.star { n = len(args) }
+			for j := 0; j<n; j++ {
+				v := next()
+				if v.Float()<0 { panic("Cannot compress negative numbers in pack") }
+				buf = packBER(buf,uint64(v.Integer()))
+			}
+		default:
+			n := it.count
+			if it.star { n = len(args) }
+			for j := 0; j<n; j++ { buf = packNumber(buf,it,next()) }
+		}
+	}
+	return ScBuffer(buf)
+}
+
+func packNumber(buf []byte, it packItem, v Scalar) []byte {
+	var b [8]byte
+	order := it.order
+	switch it.code {
+	case 'n','N': order = binary.BigEndian
+	case 'v','V': order = binary.LittleEndian
+	}
+	switch size := packSize(it.code); {
+	case it.code=='f':
+		order.PutUint32(b[:],math.Float32bits(float32(v.Float())))
+	case it.code=='d':
+		order.PutUint64(b[:],math.Float64bits(v.Float()))
+	case size==1:
+		b[0] = byte(packInt(v))
+	case size==2:
+		order.PutUint16(b[:],uint16(packInt(v)))
+	case size==4:
+		order.PutUint32(b[:],uint32(packInt(v)))
+	default:
+		order.PutUint64(b[:],packInt(v))
+	}
+	return append(buf,b[:packSize(it.code)]...)
+}
+// Integers are truncated to the width of the field. Floats beyond int64 keep their unsigned value.
+func packInt(v Scalar) uint64 {
+	if v.IsFloat() {
+		if f := v.Float(); f>=math.MaxInt64 { return uint64(f) }
+	}
+	return uint64(v.Integer())
+}
+
+func packBER(buf []byte, v uint64) []byte {
+	var b [10]byte
+	i := len(b)-1
+	b[i] = byte(v&0x7f)
+	for v >>= 7; v>0; v >>= 7 {
+		i--
+		b[i] = byte(v&0x7f)|0x80
+	}
+	return append(buf,b[i:]...)
+}
+
+// b, B, h and H: packs a string of bits ("0101...") or hex digits.
+func packBits(it packItem, s []byte) []byte {
+	n := it.count
+	if it.star { n = len(s) }
+	if n>len(s) { s = append(s,make([]byte,n-len(s))...) }
+	hex := it.code=='h' || it.code=='H'
+	per := 8
+	if hex { per = 2 }
+	out := make([]byte,(n+per-1)/per)
+	for j := 0; j<n; j++ {
+		var v byte
+		c := s[j]
+		if hex {
+			switch {
+			case c>='0' && c<='9': v = c-'0'
+			case c>='a' && c<='f': v = c-'a'+10
+			case c>='A' && c<='F': v = c-'A'+10
+			}
+			if it.code=='H' { v <<= 4*uint(1-j%2) } else { v <<= 4*uint(j%2) }
+		} else {
+			v = c&1
+			if it.code=='B' { v <<= uint(7-j%8) } else { v <<= uint(j%8) }
+		}
+		out[j/per] |= v
+	}
+	return out
+}
+
+/*
+Unpacks a buffer according to the template (see Pack). Integer and float items yield
+numbers, a, A and Z byte buffers and the bit and hex items strings. Items, that exceed
+the data, are left out.
+*/
+func Unpack(tpl string, data []byte) AV {
+	var res AV
+	pos := 0
+	for _,it := range parsePackTemplate(tpl) {
+		rest := data[pos:]
+		switch it.code {
+		case 'a','A','Z':
+			n := it.count
+			if it.star || n>len(rest) { n = len(rest) }
+			field := rest[:n]
+			switch it.code {
+			case 'A':
+				field = []byte(strings.TrimRight(string(field)," \x00\t\n\r\f"))
+			case 'Z':
+				if z := strings.IndexByte(string(field),0); z>=0 {
+					field = field[:z]
+					if it.star { n = z+1 }
+				}
+			}
+			res = append(res,append(ScBuffer(nil),field...))
+			pos += n
+		case 'b','B','h','H':
+			per := 8
+			if it.code=='h' || it.code=='H' { per = 2 }
+			n := it.count
+			if it.star || n>len(rest)*per { n = len(rest)*per }
+			res = append(res,ScString(unpackBits(it.code,rest,n)))
+			pos += (n+per-1)/per
+		case 'x':
+			n := it.count
+			if it.star { n = 0 }
+			if n>len(rest) { panic("'x' outside of string in unpack") }
+			pos += n
+		case 'X':
+			n := it.count
+			if it.star { n = 0 }
+			if n>pos { panic("'X' outside of string in unpack") }
+			pos -= n
+		case '@':
+			if it.star { pos = len(data); continue }
+			if it.count>len(data) { panic("'@' outside of string in unpack") }
+			pos = it.count
+		case 'w':
+			for j := 0; it.star || j<it.count; j++ {
+				v,w := unpackBER(data[pos:])
+				if w==0 { break }
+				res = append(res,v)
+				pos += w
+			}
+		default:
+			size := packSize(it.code)
+			for j := 0; it.star || j<it.count; j++ {
+				if pos+size>len(data) { break }
+				res = append(res,unpackNumber(it,data[pos:pos+size]))
+				pos += size
+			}
+		}
+	}
+	return res
+}
+
+func unpackNumber(it packItem, b []byte) Scalar {
+	order := it.order
+	switch it.code {
+	case 'n','N': order = binary.BigEndian
+	case 'v','V': order = binary.LittleEndian
+	}
+	switch it.code {
+	case 'c': return ScInt(int8(b[0]))
+	case 'C': return ScInt(b[0])
+	case 's': return ScInt(int16(order.Uint16(b)))
+	case 'S': return ScInt(order.Uint16(b))
+	case 'n','v':
+		if it.bang { return ScInt(int16(order.Uint16(b))) }
+		return ScInt(order.Uint16(b))
+	case 'l': return ScInt(int32(order.Uint32(b)))
+	case 'L': return ScInt(order.Uint32(b))
+	case 'N','V':
+		if it.bang { return ScInt(int32(order.Uint32(b))) }
+		return ScInt(order.Uint32(b))
+	case 'q': return ScInt(int64(order.Uint64(b)))
+	case 'Q':
+		v := order.Uint64(b)
+		if v>math.MaxInt64 { return ScFloat(float64(v)) }
+		return ScInt(v)
+	case 'f': return ScFloat(math.Float32frombits(order.Uint32(b)))
+	case 'd': return ScFloat(math.Float64frombits(order.Uint64(b)))
+	}
+	return null
+}
+
+// Decodes a BER compressed integer. w is 0, if the data ends within the number.
+func unpackBER(b []byte) (v Scalar, w int) {
+	var u uint64
+	for i,c := range b {
+		u = u<<7 | uint64(c&0x7f)
+		if c&0x80==0 {
+			if u>math.MaxInt64 { return ScFloat(float64(u)),i+1 }
+			return ScInt(u),i+1
+		}
+	}
+	return null,0
+}
+
+func unpackBits(code byte, b []byte, n int) string {
+	out := make([]byte,n)
+	for j := range out {
+		switch code {
+		case 'b': out[j] = '0'+(b[j/8]>>uint(j%8))&1
+		case 'B': out[j] = '0'+(b[j/8]>>uint(7-j%8))&1
+		case 'h': out[j] = "0123456789abcdef"[(b[j/2]>>(4*uint(j%2)))&0xf]
+		case 'H': out[j] = "0123456789abcdef"[(b[j/2]>>(4*uint(1-j%2)))&0xf]
+		}
+	}
+	return string(out)
+}
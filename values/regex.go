@@ -0,0 +1,381 @@
+/*
+Copyright (c) 2021 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package values
+
+import "regexp"
+import "container/list"
+import "sync"
+import "strings"
+import "unicode/utf8"
+import "fmt"
+import "unsafe"
+import "github.com/byte-mug/dream/backtrack"
+
+/*
+A compiled regex. The engines are RE2 (package regexp), which is the default, and a
+backtracking engine (package backtrack), which supports lookaround, backreferences,
+atomic groups and possessive quantifiers.
+*/
+type Regex interface{
+	// Finds the leftmost match at or after start, with offsets relative to s, or nil.
+	// Assertions (^, \A, \b, lookbehind) see the text before start, as if s was matched as a whole.
+	FindAt(s string, start int) []int
+	NumSubexp() int
+	SubexpNames() []string
+	String() string
+}
+
+/* Compiles a pattern in Perl syntax, translated by PatternSource. */
+type RegexEngine func(src string) (Regex,error)
+
+var regexEngines = map[string]RegexEngine{
+	"re2": compileRE2,
+	"backtrack": compileBacktrack,
+}
+
+/*
+Makes an engine available for "use re qw(name);". Call it during initialization only.
+*/
+func RegisterRegexEngine(name string, e RegexEngine) { regexEngines[name] = e }
+
+func HasRegexEngine(name string) bool { return regexEngines[name]!=nil }
+
+type re2Regex struct{
+	*regexp.Regexp
+	src string
+	at *regexp.Regexp // \A(?s:.)(?s:.*?)(src), see FindAt
+}
+func (r re2Regex) String() string { return r.src }
+/*
+RE2 can't start matching in the middle of a string. So the match starts one rune before
+start, which r.at skips, so that ^, \A and \b see the text before start.
+*/
+func (r re2Regex) FindAt(s string, start int) []int {
+	if start==0 { return r.FindStringSubmatchIndex(s) }
+	if start>len(s) { return nil }
+	_,w := utf8.DecodeLastRuneInString(s[:start])
+	loc := r.at.FindStringSubmatchIndex(s[start-w:])
+	if loc==nil { return nil }
+	loc = loc[2:]
+	for i := range loc {
+		if loc[i]>=0 { loc[i] += start-w }
+	}
+	return loc
+}
+func compileRE2(src string) (Regex,error) {
+	rx,err := regexp.Compile(src)
+	if err!=nil { return nil,err }
+	at,err := regexp.Compile(`\A(?s:.)(?s:.*?)(`+src+`)`)
+	if err!=nil { return nil,err }
+	return re2Regex{rx,src,at},nil
+}
+func compileBacktrack(src string) (Regex,error) {
+	rx,err := backtrack.Compile(src)
+	if err!=nil { return nil,err }
+	return rx,nil
+}
+
+/*
+Compiles a pattern with the given engine. The default engine ("") is RE2, but patterns, that
+RE2 can't compile (such as lookaround and backreferences), use the backtracking engine.
+*/
+func CompileRegexWith(engine, src string) (Regex,error) {
+	if engine!="" {
+		e := regexEngines[engine]
+		if e==nil { return nil,fmt.Errorf("unknown regex engine: %s",engine) }
+		return e(src)
+	}
+	rx,err := compileRE2(src)
+	if err==nil { return rx,nil }
+	if rx2,err2 := compileBacktrack(src); err2==nil { return rx2,nil }
+	return nil,err
+}
+
+/*
+Compiles a pattern literal with its modifiers. The modifier b selects the backtracking engine.
+*/
+func CompilePattern(src, flags string) (Regex,error) {
+	engine := ""
+	if strings.ContainsRune(flags,'b') { engine = "backtrack" }
+	return CompileRegexWith(engine,PatternSource(src,flags))
+}
+
+/*
+Creates a qr// object, a reference to a compiled regex.
+*/
+func NewRegexRef(rx Regex) *ScReference {
+	r := AllocScReference()
+	r.Data = rx
+	return r
+}
+
+/*
+Returns the compiled regex of a qr// object, or nil, if s isn't one.
+*/
+func RegexOf(s Scalar) Regex {
+	if r,ok := s.(*ScReference); ok {
+		if rx,ok := r.Data.(Regex); ok { return rx }
+	}
+	return nil
+}
+
+const regexCacheSize = 256
+
+type regexEntry struct{
+	key string // engine, NUL, source
+	rx Regex
+}
+
+// Patterns compiled at runtime, least recently used first.
+var regexCache struct{
+	sync.Mutex
+	index map[string]*list.Element
+	lru list.List
+}
+
+/*
+Obtains the regex for a runtime pattern. A qr// object is used as it is. Everything
+else is compiled from its string value, with the given engine ("" for the default).
+Compiled patterns are kept in a bounded cache, so a pattern in a loop is compiled only once.
+*/
+func CompileRegex(s Scalar, engine string) Regex {
+	if rx := RegexOf(s); rx!=nil { return rx }
+	src := s.String()
+	key := engine+"\x00"+src
+	
+	c := &regexCache
+	c.Lock()
+	defer c.Unlock()
+	if e,ok := c.index[key]; ok {
+		c.lru.MoveToBack(e)
+		return e.Value.(*regexEntry).rx
+	}
+	rx,err := CompileRegexWith(engine,src)
+	if err!=nil { panic("Invalid regex: "+err.Error()) }
+	if c.index==nil { c.index = make(map[string]*list.Element) }
+	if c.lru.Len()>=regexCacheSize {
+		e := c.lru.Front()
+		delete(c.index,e.Value.(*regexEntry).key)
+		c.lru.Remove(e)
+	}
+	c.index[key] = c.lru.PushBack(&regexEntry{key,rx})
+	return rx
+}
+
+type posEntry struct{
+	data *byte // the text of the value, pos has been recorded for (see valueData)
+	n int
+	pos int
+	empty bool // the last match was empty
+}
+
+/*
+The match positions (pos()) of scalar variables, keyed by their storage. The variable gets
+a copy of its value, when pos is recorded, and pos is only valid as long as the variable
+holds that copy. So any assignment resets pos, even of an equal value.
+*/
+type PosTable map[interface{}]posEntry
+
+func slotKey(slot ScalarSlot) interface{} {
+	switch v := slot.(type) {
+	case *slotAV: return v[0]
+	}
+	return slot
+}
+// The identity of a string or a buffer: its text in memory.
+func valueData(v Scalar) (*byte,int,bool) {
+	switch x := v.(type) {
+	case ScString: return unsafe.StringData(string(x)),len(x),true
+	case ScBuffer:
+		if len(x)==0 { return nil,0,true }
+		return &x[0],len(x),true
+	}
+	return nil,0,false
+}
+
+/* Returns pos() of the slot, or -1, if none. empty is true, if the last match was empty. */
+func (pt PosTable) Get(slot ScalarSlot) (pos int, empty bool) {
+	e,ok := pt[slotKey(slot)]
+	if !ok { return -1,false }
+	if d,n,ok := valueData(slot.Get()); !ok || d!=e.data || n!=e.n { return -1,false }
+	return e.pos,e.empty
+}
+/*
+Sets pos() of the slot. A negative position resets it. Unless pos is set already, the slot
+gets its own copy of its value first.
+*/
+func (pt PosTable) Set(slot ScalarSlot, pos int, empty bool) {
+	k := slotKey(slot)
+	if pos<0 { delete(pt,k); return }
+	if old,_ := pt.Get(slot); old<0 {
+		v := slot.Get()
+		if v.IsBytes() {
+			slot.Set(append(ScBuffer(nil),v.Bytes()...))
+		} else {
+			slot.Set(ScString(strings.Clone(v.String())))
+		}
+	}
+	d,n,_ := valueData(slot.Get())
+	pt[k] = posEntry{d,n,pos,empty}
+}
+/* Forgets pos() of the scalars in regs, as they are about to be freed. */
+func (pt PosTable) Release(regs []Scalar) {
+	for i := range regs { delete(pt,&regs[i]) }
+}
+
+/*
+Applies the modifiers i, m, s and x to a pattern. Other modifiers (g, r, e, b) don't
+affect the pattern and are ignored.
+The result is enclosed in (?flags:...), so it can be interpolated into larger patterns.
+*/
+func PatternSource(src, flags string) string {
+	mods := ""
+	for _,c := range flags {
+		switch c {
+		case 'i','m','s':
+			if !strings.ContainsRune(mods,c) { mods += string(c) }
+		case 'x':
+			src = stripExtended(src)
+		}
+	}
+	return "(?"+mods+":"+src+")"
+}
+
+// Removes whitespace and comments from a pattern with /x. Character classes are kept as they are.
+func stripExtended(src string) string {
+	var b strings.Builder
+	class := false
+	for i := 0; i<len(src); i++ {
+		c := src[i]
+		switch {
+		case c=='\\' && i+1<len(src):
+			b.WriteByte(c)
+			i++
+			c = src[i]
+		case class:
+			if c==']' { class = false }
+		case c=='[':
+			class = true
+			b.WriteByte(c)
+			if i+1<len(src) && src[i+1]=='^' { i++; b.WriteByte('^') }
+			if i+1<len(src) && src[i+1]==']' { i++; b.WriteByte(']') } // []...] and [^]...]
+			continue
+		case c==' ',c=='\t',c=='\n',c=='\r',c=='\f',c=='\v':
+			continue
+		case c=='#':
+			for i<len(src) && src[i]!='\n' { i++ }
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+/*
+The last successful match: $&, $1..., @-, @+, %+ and %-.
+
+Match variables are dynamically scoped: a sub sees the match of its caller, until it does a
+successful match of its own, which is gone, when the sub returns. A failed match doesn't
+touch them, so the groups of the previous successful match remain available.
+*/
+type Match struct{
+	Subject string
+	Bytes bool // the subject was a buffer
+	Loc []int // offsets of the groups, as returned by FindStringSubmatchIndex
+	Names []string // names of the groups, as returned by SubexpNames
+}
+
+func (m *Match) value(i, j int) Scalar {
+	if m.Bytes { return ScBuffer(m.Subject[i:j]) }
+	return ScString(m.Subject[i:j])
+}
+
+/* Returns the group n ($&, if n is 0), or undef, if it didn't participate in the match. */
+func (m *Match) Group(n int) Scalar {
+	if m==nil || n<0 || 2*n+1>=len(m.Loc) || m.Loc[2*n]<0 { return null }
+	return m.value(m.Loc[2*n],m.Loc[2*n+1])
+}
+/* $` */
+func (m *Match) Prematch() Scalar {
+	if m==nil { return null }
+	return m.value(0,m.Loc[0])
+}
+/* $' */
+func (m *Match) Postmatch() Scalar {
+	if m==nil { return null }
+	return m.value(m.Loc[1],len(m.Subject))
+}
+
+/*
+Returns the start offsets (@-) or the end offsets (@+) of the groups, in characters (bytes, if
+the subject was a buffer). As in Perl, @+ has an element for every group, while @- ends with
+the last participating one.
+*/
+func (m *Match) Offsets(ends bool) *AV {
+	av := new(AV)
+	if m==nil { return av }
+	n := len(m.Loc)/2
+	if !ends {
+		for n>1 && m.Loc[2*(n-1)]<0 { n-- }
+	}
+	k := 0
+	if ends { k = 1 }
+	for i := 0; i<n; i++ {
+		if m.Loc[2*i+k]<0 {
+			av.Push(null)
+		} else {
+			off := m.Loc[2*i+k]
+			if !m.Bytes { off = CharOffset(m.Subject,off) }
+			av.Push(ScInt(off))
+		}
+	}
+	return av
+}
+
+/*
+Returns the named groups. If all is false (%+), every name maps to the leftmost group of that
+name, that participated in the match. If all is true (%-), every name maps to a reference to
+an array of all groups of that name.
+*/
+func (m *Match) Named(all bool) *HV {
+	hv := new(HV)
+	if m==nil { return hv }
+	for i,name := range m.Names {
+		if name=="" { continue }
+		key := ScString(name)
+		if all {
+			slot := hv.Put(key)
+			r,ok := slot.Get().(*ScReference)
+			if !ok {
+				r = AllocScReference()
+				r.Data = new(AV)
+				slot.Set(r)
+			}
+			r.Data.(*AV).Push(m.Group(i))
+		} else if m.Loc[2*i]>=0 && hv.Get(key)==nil {
+			hv.Put(key).Set(m.Group(i))
+		}
+	}
+	return hv
+}
@@ -0,0 +1,216 @@
+/*
+Copyright (c) 2021 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package values
+
+import "fmt"
+import "sort"
+import "strconv"
+import "strings"
+import "unicode/utf8"
+
+/*
+A compiled transliteration, tr/SEARCH/REPLACE/cdsr.
+*/
+type Trans struct{
+	search []rune
+	repl []rune
+	index map[rune]int // position of a character in search (the first one counts)
+	sorted []rune // search, sorted and without duplicates (for c)
+	
+	Complement bool // c: the characters, that are not in SEARCH
+	Delete bool // d: delete characters without a replacement
+	Squeeze bool // s: squeeze runs of the same replacement character
+	Identity bool // nothing is replaced, the characters are only counted
+}
+
+/*
+Expands a SEARCH or REPLACE list: ranges (a-z) and escapes (\n, \-, \\, \x41, \x{263A}, ...).
+A "-" at the start or at the end is a literal.
+*/
+func transList(s string) ([]rune,error) {
+	var chars []rune
+	var ranged []bool // chars[i] is the end of a range
+	for i := 0; i<len(s); {
+		r,w := utf8.DecodeRuneInString(s[i:])
+		i += w
+		lit := false
+		if r=='\\' && i<len(s) {
+			var err error
+			if r,i,err = transEscape(s,i); err!=nil { return nil,err }
+			lit = true
+		}
+		if r=='-' && !lit && len(chars)>0 && i<len(s) && !ranged[len(chars)-1] {
+			hi,w := utf8.DecodeRuneInString(s[i:])
+			i += w
+			if hi=='\\' && i<len(s) {
+				var err error
+				if hi,i,err = transEscape(s,i); err!=nil { return nil,err }
+			}
+			lo := chars[len(chars)-1]
+			if hi<lo { return nil,fmt.Errorf("Invalid range \"%c-%c\" in transliteration operator",lo,hi) }
+			for c := lo+1; c<=hi; c++ {
+				chars = append(chars,c)
+				ranged = append(ranged,true)
+			}
+			ranged[len(chars)-1] = true
+			continue
+		}
+		chars = append(chars,r)
+		ranged = append(ranged,false)
+	}
+	return chars,nil
+}
+
+// Parses an escape sequence after the backslash at s[i-1].
+func transEscape(s string, i int) (rune,int,error) {
+	c := s[i]
+	i++
+	switch c {
+	case 'n': return '\n',i,nil
+	case 't': return '\t',i,nil
+	case 'r': return '\r',i,nil
+	case 'f': return '\f',i,nil
+	case 'e': return 0x1b,i,nil
+	case 'a': return '\a',i,nil
+	case '0':
+		j := i
+		for j<len(s) && j<i+2 && s[j]>='0' && s[j]<='7' { j++ }
+		v,_ := strconv.ParseUint("0"+s[i:j],8,32)
+		return rune(v),j,nil
+	case 'x':
+		var hex string
+		if i<len(s) && s[i]=='{' {
+			end := strings.IndexByte(s[i:],'}')
+			if end<0 { return 0,i,fmt.Errorf("Missing right brace on \\x{}") }
+			hex,i = s[i+1:i+end],i+end+1
+		} else {
+			j := i
+			for j<len(s) && j<i+2 && strings.IndexByte("0123456789abcdefABCDEF",s[j])>=0 { j++ }
+			hex,i = s[i:j],j
+		}
+		v,err := strconv.ParseUint(hex,16,32)
+		if err!=nil || v>utf8.MaxRune { return 0,i,fmt.Errorf("Invalid escape \\x%s in transliteration operator",hex) }
+		return rune(v),i,nil
+	}
+	r,w := utf8.DecodeRuneInString(s[i-1:])
+	return r,i-1+w,nil
+}
+
+/*
+Compiles tr/SEARCH/REPLACE/flags. The flag r doesn't affect the table and is ignored.
+*/
+func NewTrans(search, repl, flags string) (*Trans,error) {
+	t := &Trans{
+		Complement: strings.ContainsRune(flags,'c'),
+		Delete: strings.ContainsRune(flags,'d'),
+		Squeeze: strings.ContainsRune(flags,'s'),
+	}
+	var err error
+	if t.search,err = transList(search); err!=nil { return nil,err }
+	if t.repl,err = transList(repl); err!=nil { return nil,err }
+	t.index = make(map[rune]int,len(t.search))
+	for i,c := range t.search {
+		if _,ok := t.index[c]; !ok { t.index[c] = i }
+	}
+	if t.Complement {
+		for c := range t.index { t.sorted = append(t.sorted,c) }
+		sort.Slice(t.sorted,func(i, j int) bool { return t.sorted[i]<t.sorted[j] })
+	}
+	if len(t.repl)==0 && !t.Delete {
+		// tr/a-z// counts, but doesn't change anything.
+		t.Identity = !t.Squeeze
+		if !t.Complement { t.repl = t.search }
+	}
+	return t,nil
+}
+
+/*
+Looks up a character. Returns its replacement, or -1, if it is to be deleted.
+ok is false, if the character isn't in SEARCH (or is in SEARCH, with c).
+*/
+func (t *Trans) lookup(c rune) (r rune, ok bool) {
+	var i int
+	if t.Complement {
+		n := sort.Search(len(t.sorted),func(i int) bool { return t.sorted[i]>=c })
+		if n<len(t.sorted) && t.sorted[n]==c { return c,false }
+		i = int(c)-n // position of c in the complement
+	} else {
+		if i,ok = t.index[c]; !ok { return c,false }
+	}
+	switch {
+	case len(t.repl)==0 && !t.Delete: return c,true // tr/a-z//cs
+	case i<len(t.repl): return t.repl[i],true
+	case t.Delete: return -1,true
+	}
+	return t.repl[len(t.repl)-1],true
+}
+
+/*
+Transliterates s. Returns the new string and the number of characters in SEARCH (or not in
+SEARCH, with c). If bytes is true, s is a buffer and translated byte by byte.
+*/
+func (t *Trans) Apply(s string, bytes bool) (string,int) {
+	var b strings.Builder
+	n := 0
+	last := rune(-1) // the last replacement character, for s
+	for i := 0; i<len(s); {
+		var c rune
+		var w int
+		if bytes {
+			c,w = rune(s[i]),1
+		} else {
+			c,w = utf8.DecodeRuneInString(s[i:])
+		}
+		i += w
+		r,ok := t.lookup(c)
+		if !ok {
+			last = -1
+			t.put(&b,c,bytes)
+			continue
+		}
+		n++
+		if r<0 { continue }
+		if t.Squeeze && r==last { continue }
+		last = r
+		t.put(&b,r,bytes)
+	}
+	return b.String(),n
+}
+func (t *Trans) put(b *strings.Builder, r rune, bytes bool) {
+	if bytes && r<0x100 {
+		b.WriteByte(byte(r))
+	} else {
+		b.WriteRune(r) // characters above \xFF are stored as UTF-8 in buffers
+	}
+}
+
+/* Transliterates a value, keeping its type (ScString or ScBuffer). */
+func (t *Trans) Scalar(s Scalar) (Scalar,int) {
+	if s.IsBytes() {
+		str,n := t.Apply(string(s.Bytes()),true)
+		return ScBuffer(str),n
+	}
+	str,n := t.Apply(s.String(),false)
+	return ScString(str),n
+}
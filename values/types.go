@@ -37,6 +37,8 @@ const (
 	T_Buffer
 	T_Reference
 	T_Module
+	T_BigInt
+	T_BigFloat
 )
 
 type Scalar interface{
@@ -182,7 +184,10 @@ func (r *ScReference) Float() float64 { return float64(int64(r.Refid)) }
 func (*ScReference) IsBytes() bool { return false }
 func (r *ScReference) String() string {
 	var t,c string
-	switch r.Data.(type) {
+	switch v := r.Data.(type) {
+	case Regex:
+		if r.Blessed==nil { return v.String() } // qr// interpolates as its pattern.
+		t = "Regexp"
 	case *Scalar: t = "SCALAR"
 	case *AV: t = "ARRAY"
 	case *HV: t = "HASH"
@@ -196,6 +201,20 @@ func (r *ScReference) AppendTo(prefix []byte) []byte { return append(prefix,r.St
 func (r *ScReference) Less(s Scalar) bool { return r.Refid < s.(*ScReference).Refid }
 func (*ScReference) Bool() bool { return true }
 
+func derefError(s Scalar, kind string) string {
+	if s==nil || s.Type()==T_Nil { return "Can't use an undefined value as "+kind+" reference" }
+	if kind=="an ARRAY" { return "Not an ARRAY reference" }
+	return "Not a "+kind[2:]+" reference"
+}
+
+/* Dereferences a scalar reference ($$ref). Panics, if s is none. */
+func DerefSV(s Scalar) *Scalar {
+	if r,ok := s.(*ScReference); ok {
+		if sv,ok := r.Data.(*Scalar); ok { return sv }
+	}
+	panic(derefError(s,"a SCALAR"))
+}
+
 
 type ScModule struct{
 	Name string
@@ -0,0 +1,232 @@
+/*
+Copyright (c) 2021 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package values
+
+import "fmt"
+import "strings"
+import "unicode/utf8"
+import "unicode/utf16"
+
+/*
+The string model: an ScString is a sequence of characters (code points), stored as UTF-8.
+An ScBuffer is a sequence of bytes. length, substr, ord and regex offsets count characters
+in strings and bytes in buffers. encode and decode convert between the two.
+*/
+
+// Converts a byte offset in s into a character offset.
+func CharOffset(s string, off int) int {
+	if off>len(s) { off = len(s) }
+	return utf8.RuneCountInString(s[:off])
+}
+// Converts a character offset in s into a byte offset.
+func ByteOffset(s string, off int) int {
+	i := 0
+	for ; off>0 && i<len(s); off-- {
+		_,w := utf8.DecodeRuneInString(s[i:])
+		i += w
+	}
+	return i
+}
+
+/* length EXPR: the number of characters (bytes in buffers). length(undef) is undef. */
+func Length(s Scalar) Scalar {
+	if s.Type()==T_Nil { return null }
+	if s.IsBytes() { return ScInt(len(s.Bytes())) }
+	return ScInt(utf8.RuneCountInString(s.String()))
+}
+
+/*
+substr EXPR,OFFSET[,LENGTH]: negative offsets count from the end, a negative length leaves
+out that many characters at the end. The result is undef, if the offset is outside.
+*/
+func Substr(s, offset, length Scalar) Scalar {
+	bytes := s.IsBytes()
+	str := s.String()
+	n := len(str)
+	if !bytes { n = utf8.RuneCountInString(str) }
+	off := int(offset.Integer())
+	if off<0 { off += n }
+	if off<0 || off>n { return null }
+	end := n
+	if length.Type()!=T_Nil {
+		l := int(length.Integer())
+		if l<0 { end = n+l } else { end = off+l }
+		if end>n { end = n }
+		if end<off { end = off }
+	}
+	if bytes { return ScBuffer(str[off:end]) }
+	i := ByteOffset(str,off)
+	return ScString(str[i:i+ByteOffset(str[i:],end-off)])
+}
+
+/* ord EXPR: the code point of the first character (the first byte in buffers), 0 if empty. */
+func Ord(s Scalar) Scalar {
+	if s.IsBytes() {
+		b := s.Bytes()
+		if len(b)==0 { return ScInt(0) }
+		return ScInt(b[0])
+	}
+	r,w := utf8.DecodeRuneInString(s.String())
+	if w==0 { return ScInt(0) }
+	return ScInt(r)
+}
+
+/* chr NUMBER: the character with that code point. Invalid code points yield U+FFFD. */
+func Chr(n Scalar) Scalar {
+	r := rune(n.Integer())
+	if !utf8.ValidRune(r) { r = utf8.RuneError }
+	return ScString(string(r))
+}
+
+/* The error modes of encode and decode (the CHECK argument, as in Perl's Encode). */
+const (
+	FB_DEFAULT = 0 // substitute: "?" when encoding, U+FFFD when decoding
+	FB_CROAK = 1 // die
+	FB_QUIET = 4 // stop at the first error and return the result so far
+)
+
+// The canonical name of an encoding: utf8, utf16, utf16le, utf16be, latin1 or ascii.
+func encodingName(enc string) string {
+	name := strings.ToLower(strings.NewReplacer("-","","_",""," ","").Replace(enc))
+	switch name {
+	case "utf8","utf8strict": return "utf8"
+	case "utf16","utf16le","utf16be","ascii": return name
+	case "latin1","iso88591": return "latin1"
+	case "usascii": return "ascii"
+	}
+	panic(fmt.Sprintf("Unknown encoding '%s'",enc))
+}
+
+/*
+encode ENCODING, STRING[, CHECK]: converts characters into bytes. UTF-16 without an
+explicit byte order is big endian with a byte order mark.
+*/
+func Encode(enc string, s string, check int) ScBuffer {
+	name := encodingName(enc)
+	var b []byte
+	for i := 0; i<len(s); {
+		r,w := utf8.DecodeRuneInString(s[i:])
+		if r==utf8.RuneError && w==1 {
+			// A byte, that isn't valid UTF-8 (from a buffer), can't be a character.
+			if check&FB_CROAK!=0 { panic(fmt.Sprintf("\"\\x%02X\" does not map to Unicode",s[i])) }
+			if check&FB_QUIET!=0 { break }
+		}
+		i += w
+		switch name {
+		case "utf8":
+			b = utf8.AppendRune(b,r)
+			continue
+		case "utf16","utf16be","utf16le":
+			if name=="utf16" && len(b)==0 { b = append(b,0xFE,0xFF) }
+			for _,u := range utf16.Encode([]rune{r}) {
+				if name=="utf16le" {
+					b = append(b,byte(u),byte(u>>8))
+				} else {
+					b = append(b,byte(u>>8),byte(u))
+				}
+			}
+			continue
+		}
+		max := rune(0xFF)
+		if name=="ascii" { max = 0x7F }
+		if r<=max {
+			b = append(b,byte(r))
+			continue
+		}
+		if check&FB_CROAK!=0 { panic(fmt.Sprintf("\"\\x{%04x}\" does not map to %s",r,enc)) }
+		if check&FB_QUIET!=0 { break }
+		b = append(b,'?')
+	}
+	if b==nil { b = []byte{} }
+	return ScBuffer(b)
+}
+
+/*
+decode ENCODING, OCTETS[, CHECK]: converts bytes into characters. UTF-16 without an
+explicit byte order uses the byte order mark, big endian if there is none.
+*/
+func Decode(enc string, b []byte, check int) ScString {
+	name := encodingName(enc)
+	var sb strings.Builder
+	fail := func(what string) bool {
+		if check&FB_CROAK!=0 { panic(fmt.Sprintf("%s \"%s\" does not map to Unicode",enc,what)) }
+		if check&FB_QUIET!=0 { return true }
+		sb.WriteRune(utf8.RuneError)
+		return false
+	}
+	switch name {
+	case "utf8":
+		for i := 0; i<len(b); {
+			r,w := utf8.DecodeRune(b[i:])
+			if r==utf8.RuneError && w<=1 {
+				if fail(fmt.Sprintf("\\x%02X",b[i])) { break }
+				i++
+				continue
+			}
+			sb.WriteRune(r)
+			i += w
+		}
+	case "latin1","ascii":
+		for _,c := range b {
+			if name=="ascii" && c>0x7F {
+				if fail(fmt.Sprintf("\\x%02X",c)) { break }
+				continue
+			}
+			sb.WriteRune(rune(c))
+		}
+	default:
+		le := name=="utf16le"
+		if name=="utf16" && len(b)>=2 {
+			switch {
+			case b[0]==0xFE && b[1]==0xFF: b = b[2:]
+			case b[0]==0xFF && b[1]==0xFE: b,le = b[2:],true
+			}
+		}
+		unit := func(i int) rune {
+			if le { return rune(b[i])|rune(b[i+1])<<8 }
+			return rune(b[i])<<8|rune(b[i+1])
+		}
+		for i := 0; i<len(b); i += 2 {
+			if i+1>=len(b) {
+				fail(fmt.Sprintf("\\x%02X",b[i]))
+				break
+			}
+			u := unit(i)
+			switch {
+			case utf16.IsSurrogate(u) && u<0xDC00 && i+3<len(b):
+				if r := utf16.DecodeRune(u,unit(i+2)); r!=utf8.RuneError {
+					sb.WriteRune(r)
+					i += 2
+					continue
+				}
+				fallthrough
+			case utf16.IsSurrogate(u):
+				if fail(fmt.Sprintf("\\x{%04x}",u)) { return ScString(sb.String()) }
+				continue
+			}
+			sb.WriteRune(u)
+		}
+	}
+	return ScString(sb.String())
+}
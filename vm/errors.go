@@ -0,0 +1,98 @@
+/*
+Copyright (c) 2021 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package vm
+
+import "text/scanner"
+import "strings"
+import "fmt"
+
+/* A frame of the stack trace of a RuntimeError. */
+type TraceFrame struct{
+	Name string // the qualified name of the sub, or the name of the module for its body
+	Body bool // the frame is a module body
+	Pos scanner.Position // the statement, that was being executed, if known
+}
+
+/*
+A runtime failure with the Dream stack trace at the point, where it occurred. Panics during
+the execution of a Procedure are turned into a RuntimeError.
+*/
+type RuntimeError struct{
+	Msg string
+	Stack []TraceFrame // innermost first
+	Cause interface{} // the original panic value
+}
+
+/* Creates a RuntimeError from a panic value, with the current call stack. */
+func (ts *ThreadState) NewRuntimeError(cause interface{}) *RuntimeError {
+	e := &RuntimeError{Msg: panicMessage(cause), Cause: cause}
+	for k := len(ts.Stack)-1; k>=0; k-- {
+		fr := ts.Stack[k]
+		tf := TraceFrame{Name: fr.RS.Proc.FullName()}
+		tf.Body = fr.RS.Proc.Parent!=nil && fr.RS.Proc==fr.RS.Proc.Parent.Main
+		if pos,ok := fr.RS.Position(); ok {
+			tf.Pos = pos
+		} else if k+1<len(ts.Stack) {
+			tf.Pos = ts.Stack[k+1].CallPos // the procedure itself has no positions (bindings)
+		}
+		e.Stack = append(e.Stack,tf)
+	}
+	return e
+}
+
+func panicMessage(cause interface{}) string {
+	switch v := cause.(type) {
+	case string: return v
+	case error: return v.Error()
+	case interface{ String() string }: return v.String()
+	}
+	return fmt.Sprint(cause)
+}
+
+/*
+Formats the error like Perl's confess:
+
+	Illegal division by zero at Mod.dm:3:10
+		Mod::div called at Mod.dm:7:1
+*/
+func (e *RuntimeError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Msg)
+	if len(e.Stack)>0 && e.Stack[0].Pos.IsValid() { b.WriteString(" at "+e.Stack[0].Pos.String()) }
+	for k := 0; k+1<len(e.Stack); k++ {
+		fr := e.Stack[k]
+		b.WriteString("\n\t")
+		if fr.Body { b.WriteString("module ") }
+		b.WriteString(fr.Name)
+		b.WriteString(" called")
+		if pos := e.Stack[k+1].Pos; pos.IsValid() { b.WriteString(" at "+pos.String()) }
+	}
+	return b.String()
+}
+
+/* Returns the original panic value, if it is an error. */
+func (e *RuntimeError) Unwrap() error {
+	err,_ := e.Cause.(error)
+	return err
+}
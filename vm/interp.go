@@ -27,7 +27,9 @@ import "github.com/byte-mug/dream/values"
 import "github.com/byte-mug/dream/allocrefl"
 import "sync"
 import "unsafe"
+import "text/scanner"
 import "fmt"
+import "sort"
 
 /*
 This structure contains everything that is supposed to be global and thread-local.
@@ -38,11 +40,38 @@ type ThreadState struct {
 	Args values.AV // @_
 	
 	Flags uint
+	
+	CallPos scanner.Position // call site of the current sub
+	
+	Context int // context requested by the caller (CTX_*), see RegisterSet.Context
+	
+	Label string // target of the pending next/last/redo, "" for the innermost loop
+	
+	Pos values.PosTable // pos() of the scalars matched with m//g
+	
+	Stack []Frame // the Dream call stack, innermost last
+}
+
+/* A sub (or module body), that is being executed. */
+type Frame struct{
+	RS *RegisterSet
+	CallPos scanner.Position // the call site, as given by ThreadState.CallPos
 }
 
 const (
 	TSF_Last uint = 1<<iota
 	TSF_Return
+	TSF_Next
+	TSF_Redo
+	
+	TSF_LoopCtl = TSF_Last|TSF_Next|TSF_Redo
+)
+
+/* The context, a sub is called in. */
+const (
+	CTX_Void = iota
+	CTX_Scalar
+	CTX_List
 )
 
 type InsOp func(ts *ThreadState, ip *int, ln int)
@@ -93,6 +122,31 @@ type RegisterSet struct {
 	HRegs []values.HV
 	
 	Proc *Procedure
+	
+	Context int // the context, the procedure has been called in (wantarray)
+	
+	Match *values.Match // the last successful match, see values.Match
+	
+	blocks []running // the instruction slices being executed, innermost last
+	blockBuf [4]running
+}
+
+type running struct{
+	code []InsOp
+	ip *int
+}
+
+/* Returns the position of the statement, that is being executed, if known. */
+func (rs *RegisterSet) Position() (scanner.Position,bool) {
+	p := rs.Proc
+	if p==nil { return scanner.Position{},false }
+	for k := len(rs.blocks)-1; k>=0; k-- {
+		b := rs.blocks[k]
+		lt := p.lineTable(b.code)
+		if lt==nil { continue }
+		if pos,ok := lt.Lookup(*b.ip-1); ok { return pos,true } // *ip is past the current instruction
+	}
+	return scanner.Position{},false
 }
 
 func (rs *RegisterSet) Sproc(p *Procedure) *RegisterSet {
@@ -107,6 +161,7 @@ func (rs *RegisterSet) Set(ts *ThreadState) (old *RegisterSet) {
 }
 func (rs *RegisterSet) SetDispose(ts *ThreadState) {
 	old := rs.Set(ts)
+	if len(ts.Pos)!=0 { ts.Pos.Release(old.SRegs) }
 	sWipe(old.SRegs)
 	aWipe(old.ARegs)
 	sRegs.FreeRaw(len(old.SRegs),old.SRegs)
@@ -144,8 +199,12 @@ func (cl *ClassLoader) findClass(name string) interface{} {
 	}
 	return nil
 }
-func (cl *ClassLoader) eraseModuleOnError(name string, failed *bool) {
-	cl.Modules.Delete(name)
+func (cl *ClassLoader) eraseModuleOnError(m *Module, failed *bool) {
+	if !*failed { return }
+	cl.Modules.Delete(m.Name)
+	for _,pm := range m.Packages {
+		if v,_ := cl.Modules.Load(pm.Name); v==pm { cl.Modules.Delete(pm.Name) }
+	}
 }
 
 func FetchModule(mod *values.ScModule) (*Module,bool) {
@@ -175,7 +234,10 @@ func LoadModule(mod *values.ScModule, ts *ThreadState) (*Module,bool) {
 	v,ok := cl.Modules.LoadOrStore(mod.Name,rlm)
 	if !ok {
 		failed := true
-		defer cl.eraseModuleOnError(mod.Name,&failed)
+		defer cl.eraseModuleOnError(rlm,&failed)
+		for _,pm := range rlm.Packages { pm.InstallInLoader() }
+		ts.Context = CTX_Void
+		ts.CallPos = scanner.Position{} // a module body has no call site
 		rlm.Main.Exec(ts)
 		failed = false
 	} else {
@@ -191,6 +253,7 @@ type Module struct{
 	Name string
 	Main *Procedure
 	Procedures sync.Map // map[string]*Procedure
+	Packages []*Module // declared in the file of the module, installed, once it is loaded
 	
 	Scalars sync.Map // map[string]*values.Scalar
 	Arrays sync.Map // map[string]*values.AV
@@ -202,10 +265,90 @@ func (m *Module) InstallInLoader() *Module {
 	return v.(*Module)
 }
 
+/*
+Makes a sub ("name" or "&name") or a variable ("$name", "@name", "%name") of the module src
+available in m. The underlying *Procedure or storage is shared, not copied. Variables are
+created in src, if they don't exist yet. Returns false, if the sub does not exist.
+*/
+func (m *Module) Import(src *Module, name string) bool {
+	var v interface{}
+	var ok bool
+	switch name[0] {
+	case '$':
+		sp := new(values.Scalar)
+		*sp = values.Null()
+		v,_ = src.Scalars.LoadOrStore(name[1:],sp)
+		m.Scalars.Store(name[1:],v)
+	case '@':
+		v,_ = src.Arrays.LoadOrStore(name[1:],new(values.AV))
+		m.Arrays.Store(name[1:],v)
+	case '%':
+		v,_ = src.Hashes.LoadOrStore(name[1:],new(values.HV))
+		m.Hashes.Store(name[1:],v)
+	case '&':
+		return m.Import(src,name[1:])
+	default:
+		if v,ok = src.Procedures.Load(name); !ok { return false }
+		m.Procedures.Store(name,v)
+	}
+	return true
+}
+
 type Procedure struct{
 	Parent *Module
 	Mets RSMetrics
 	Instrs []InsOp
+	
+	Name string // the name of the sub, "" for a module body
+	Lines LineTable // the source positions of Instrs
+	Blocks map[*InsOp]*LineTable // the source positions of nested slices (loop bodies, ...), by their first instruction
+}
+
+func (p *Procedure) lineTable(code []InsOp) *LineTable {
+	if len(code)==0 { return nil }
+	if len(p.Instrs)>0 && &code[0]==&p.Instrs[0] { return &p.Lines }
+	return p.Blocks[&code[0]]
+}
+
+/* Returns the qualified name of the procedure, as shown in stack traces. */
+func (p *Procedure) FullName() string {
+	mod := "?"
+	if p.Parent!=nil {
+		mod = p.Parent.Name
+		if p==p.Parent.Main { return mod }
+	}
+	if p.Name=="" { return mod+"::__ANON__" }
+	return mod+"::"+p.Name
+}
+
+/*
+Maps instructions to the source positions of the statements, they were compiled from:
+Pos[k] applies to the instructions from Start[k] on, up to Start[k+1].
+*/
+type LineTable struct{
+	Start []int
+	Pos []scanner.Position
+}
+
+/* Records, that the instructions from start on belong to the statement at pos. */
+func (lt *LineTable) Add(start int, pos scanner.Position) {
+	if n := len(lt.Start); n>0 && lt.Start[n-1]>=start {
+		// A later statement without instructions of its own is superseded.
+		lt.Start,lt.Pos = lt.Start[:n-1],lt.Pos[:n-1]
+		lt.Add(start,pos)
+		return
+	}
+	lt.Start = append(lt.Start,start)
+	lt.Pos = append(lt.Pos,pos)
+}
+/* Adds the entries of o, for instructions, that have been appended at offset. */
+func (lt *LineTable) Merge(o *LineTable, offset int) {
+	for k,start := range o.Start { lt.Add(start+offset,o.Pos[k]) }
+}
+func (lt *LineTable) Lookup(ip int) (scanner.Position,bool) {
+	k := sort.SearchInts(lt.Start,ip+1)-1
+	if k<0 { return scanner.Position{},false }
+	return lt.Pos[k],true
 }
 func (p *Procedure) GetCl() *ClassLoader {
 	pp := p.Parent
@@ -214,24 +357,63 @@ func (p *Procedure) GetCl() *ClassLoader {
 }
 
 func (p *Procedure) Exec(ts *ThreadState) {
-	defer p.Mets.Alloc().Sproc(p).Set(ts).SetDispose(ts)
+	caller := ts.RS
+	rs := p.Mets.Alloc().Sproc(p)
+	rs.Set(ts)
+	ts.Stack = append(ts.Stack,Frame{rs,ts.CallPos})
+	defer ts.leave(caller)
+	ts.RS.Context = ts.Context
+	// Match variables are dynamically scoped: inherited from the caller, restored on return.
+	if caller!=nil { ts.RS.Match = caller.Match }
 	slice := p.Instrs
 	i,n := 0,len(slice)
+	rs.blocks = append(rs.blockBuf[:0],running{slice,&i})
 	for i<n {
 		f := slice[i]
 		i++
 		f(ts,&i,n)
 	}
-	ts.Flags &= ^TSF_Return
+	ts.Flags &= ^(TSF_Return|TSF_LoopCtl) // next and last don't leave the sub
+}
+
+/*
+Returns from a procedure to the caller. A panic is turned into a RuntimeError, while the
+call stack is still intact.
+*/
+func (ts *ThreadState) leave(caller *RegisterSet) {
+	rec := recover()
+	if rec!=nil {
+		if _,ok := rec.(*RuntimeError); !ok { rec = ts.NewRuntimeError(rec) }
+	}
+	ts.Stack = ts.Stack[:len(ts.Stack)-1]
+	caller.SetDispose(ts)
+	if rec!=nil { panic(rec) }
 }
 
 func (ts *ThreadState) RunSlice(slice []InsOp) {
+	rs := ts.RS
 	i,n := 0,len(slice)
+	rs.blocks = append(rs.blocks,running{slice,&i})
 	for i<n {
 		f := slice[i]
 		i++
 		f(ts,&i,n)
 	}
+	rs.blocks = rs.blocks[:len(rs.blocks)-1]
+}
+
+/*
+Runs a slice like RunSlice, but recovers from a panic (see eval). Returns the value of the
+panic, or nil.
+*/
+func (ts *ThreadState) RunProtected(slice []InsOp) (rec interface{}) {
+	rs := ts.RS
+	depth := len(rs.blocks)
+	defer func() {
+		if rec = recover(); rec!=nil { rs.blocks = rs.blocks[:depth] }
+	}()
+	ts.RunSlice(slice)
+	return nil
 }
 
 func NewThreadState() (ts *ThreadState) {
@@ -242,6 +424,7 @@ func NewThreadState() (ts *ThreadState) {
 func (ts *ThreadState) GoExec(p *Procedure) {
 	nts := NewThreadState()
 	nts.Args = append(nts.Args[:0],ts.Args...)
+	nts.CallPos = ts.CallPos
 	go nts.SafeExec(p)
 }
 func debugrecover(){